@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+	"github.com/haboustak/goff/internal/vulndb"
+)
+
+var approveVulnDb string
+
+var cmdApprove = &Command{
+	Name: "approve",
+	Run:  approve,
+	Usage: `Usage:
+    goff [-h] approve -proxy ROOT -quarantine QUARANTINE [-manifest id]
+                      [-vulndb dir] [-policy file]
+                      [-audit-log file -audit-key file] [-operator name]
+                      [modules]
+
+Move quarantined modules into the proxy storage
+
+Modules are uploaded to QUARANTINE by upload -quarantine or the serve upload
+API. The modules named path@version, the modules listed by the -manifest of
+their upload, or every quarantined module are approved.
+
+Before anything is moved, the modules are checked against the -policy file,
+including its license rules (see "goff help download"), their zip and .info
+files are checked like upload does, and they are checked against the
+vulnerability database snapshot in ROOT, or in -vulndb, written by download
+-vulndb (see "goff help audit-vulns"). Nothing is approved if any module
+fails a check.
+
+Approved modules are moved to ROOT and listed in an approve manifest in
+ROOT, and are added to the audit log.
+
+Options:
+    -audit-log  append approved modules to an audit log
+                (default=GOFF_AUDIT_LOG)
+    -audit-key  key used to sign audit records (default=GOFF_AUDIT_KEY)
+    -h          show this help
+    -manifest   approve the quarantined modules of an upload manifest
+    -operator   name recorded in the audit log (default=GOFF_OPERATOR or the
+                current user)
+    -policy     file of module allow and deny rules (default=GOFF_POLICY)
+    -proxy      storage of the proxy to move modules to
+    -quarantine storage that holds modules until they are approved
+                (default=GOFF_QUARANTINE)
+    -vulndb     module set that holds the vulnerability database
+                (default=ROOT)
+`,
+}
+
+func init() {
+	cmdApprove.Flags.StringVar(&proxy, "proxy", "", "proxy storage to move modules to")
+	cmdApprove.Flags.StringVar(&quarantineManifest, "manifest", "", "manifest ID of the modules to approve")
+	cmdApprove.Flags.StringVar(&approveVulnDb, "vulndb", "", "module set that holds the vulnerability database")
+	addQuarantineFlag(&cmdApprove.Flags, "storage that holds modules until they are approved")
+	addAuditFlags(&cmdApprove.Flags)
+	addPolicyFlag(&cmdApprove.Flags)
+}
+
+func approve(self *Command) (err error) {
+	if proxy == "" {
+		return fmt.Errorf("You must specify the proxy storage with -proxy")
+	}
+
+	if quarantineDir == "" {
+		return fmt.Errorf("You must specify the quarantine with -quarantine")
+	}
+
+	if err := checkQuarantineDir([]string{proxy}); err != nil {
+		return err
+	}
+
+	q, err := openQuarantine()
+	if err != nil {
+		return err
+	}
+
+	dst, err := module.CreateStorage(proxy)
+	if err != nil {
+		return fmt.Errorf("Failed to open proxy storage %v: %v", proxy, err)
+	}
+
+	dbStore, dbSpec := dst, proxy
+	if approveVulnDb != "" {
+		if dbStore, err = module.OpenStorage(approveVulnDb); err != nil {
+			return fmt.Errorf("Failed to open module set %v: %v", approveVulnDb, err)
+		}
+		dbSpec = approveVulnDb
+	}
+
+	if !vulndb.Exists(dbStore) {
+		return fmt.Errorf("No vulnerability database in %v, use download -vulndb", dbSpec)
+	}
+
+	modules, err := selectQuarantined(q, self.Flags.Args())
+	if err != nil {
+		return err
+	}
+
+	policy, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+
+	violations, err := checkPolicy(policy, q, modules)
+	if err != nil {
+		return err
+	}
+
+	if err := reportViolations(violations); err != nil {
+		return err
+	}
+
+	if err := checkModuleFiles(q, modules); err != nil {
+		if _, invalid := err.(*module.InfoError); invalid {
+			logger.Security("Quarantine has invalid module metadata", "error", err)
+		}
+		return err
+	}
+
+	if err := reportVulns(dbStore, modules, true); err != nil {
+		return err
+	}
+
+	auditLog, err := openAuditLog()
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+
+	manifest := module.NewManifest("approve", currentOperator(), quarantineDir, proxy)
+	defer func() {
+		if saveErr := saveManifest(dst, manifest); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	approved := 0
+	for i, m := range modules {
+		logger.Info("Approving module", "module", m, "progress", fmt.Sprintf("%v/%v", i+1, len(modules)))
+
+		copied, err := uploadModule(dst, q, m)
+		if err != nil {
+			return fmt.Errorf("Failed to approve %v: %v", m, err)
+		}
+
+		if copied {
+			if err := recordUpload(dst, manifest, auditLog, m); err != nil {
+				return err
+			}
+			approved++
+		}
+
+		if err := removeQuarantined(q, m); err != nil {
+			return err
+		}
+	}
+
+	logger.Info(fmt.Sprintf("Approved %v of %v modules to %v", approved, len(modules), proxy),
+		"approved", approved, "modules", len(modules), "proxy", proxy)
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/haboustak/goff/internal/audit"
+	"github.com/haboustak/goff/internal/module"
+	"os"
+	"os/user"
+	"path"
+	"time"
+)
+
+var (
+	auditLogFile  string
+	auditKeyFile  string
+	auditOperator string
+	auditVerify   bool
+	auditGenKey   string
+	auditAction   string
+	auditModule   string
+	auditVersion  string
+	auditUser     string
+	auditManifest string
+	auditSince    string
+	auditJSON     bool
+)
+
+var cmdAudit = &Command{
+	Name: "audit",
+	Run:  auditQuery,
+	Usage: `Usage:
+    goff [-h] audit [-log file] [-key file] [-verify] [-action name]
+                    [-module pattern] [-version version] [-operator name]
+                    [-manifest id] [-since time] [-json]
+    goff [-h] audit -genkey file
+
+Query the audit log of modules collected by download, uploaded by upload or
+the serve upload API, and approved or rejected from quarantine
+
+Each record lists the action, module version, mod and zip hashes, source,
+target, operator and manifest ID. Records are signed with an ed25519 key and
+chained by hash, so that changes to the log can be detected with -verify.
+
+download, upload, approve, reject and serve write audit records when
+-audit-log and -audit-key are set, or when the GOFF_AUDIT_LOG and
+GOFF_AUDIT_KEY environment variables are set. The operator is set with
+-operator, the GOFF_OPERATOR environment variable or the current user name.
+serve records the authenticated user of each upload instead.
+
+Options:
+    -action     show records for an action: download, upload, upload-api,
+                approve or reject
+    -genkey     create a new signing key in a file
+    -h          show this help
+    -json       print records as JSON lines
+    -key        signing key or public key used by -verify
+                (default=GOFF_AUDIT_KEY)
+    -log        audit log file (default=GOFF_AUDIT_LOG)
+    -manifest   show records for a manifest ID
+    -module     show records for module paths matching a glob pattern
+    -operator   show records for an operator
+    -since      show records since a time, as 2006-01-02 or RFC 3339
+    -verify     check the signatures and hash chain of every record
+    -version    show records for a module version
+`,
+}
+
+func init() {
+	cmdAudit.Flags.StringVar(&auditLogFile, "log", os.Getenv("GOFF_AUDIT_LOG"), "audit log file")
+	cmdAudit.Flags.StringVar(&auditKeyFile, "key", os.Getenv("GOFF_AUDIT_KEY"), "signing key or public key")
+	cmdAudit.Flags.BoolVar(&auditVerify, "verify", false, "check signatures and the hash chain")
+	cmdAudit.Flags.StringVar(&auditGenKey, "genkey", "", "create a new signing key")
+	cmdAudit.Flags.StringVar(&auditAction, "action", "", "filter by action")
+	cmdAudit.Flags.StringVar(&auditModule, "module", "", "filter by module path pattern")
+	cmdAudit.Flags.StringVar(&auditVersion, "version", "", "filter by module version")
+	cmdAudit.Flags.StringVar(&auditUser, "operator", "", "filter by operator")
+	cmdAudit.Flags.StringVar(&auditManifest, "manifest", "", "filter by manifest ID")
+	cmdAudit.Flags.StringVar(&auditSince, "since", "", "filter by time")
+	cmdAudit.Flags.BoolVar(&auditJSON, "json", false, "print records as JSON lines")
+}
+
+// Add the flags that configure the audit log to a command
+func addAuditFlags(flags *flag.FlagSet) {
+	flags.StringVar(&auditLogFile, "audit-log", os.Getenv("GOFF_AUDIT_LOG"), "audit log file")
+	flags.StringVar(&auditKeyFile, "audit-key", os.Getenv("GOFF_AUDIT_KEY"), "audit log signing key")
+	flags.StringVar(&auditOperator, "operator", os.Getenv("GOFF_OPERATOR"), "operator recorded in the audit log")
+}
+
+// Open the audit log for appending, or return nil if it is not configured
+func openAuditLog() (*audit.Log, error) {
+	if auditLogFile == "" {
+		return nil, nil
+	}
+
+	if auditKeyFile == "" {
+		return nil, fmt.Errorf("The audit log requires a signing key, set -audit-key or GOFF_AUDIT_KEY")
+	}
+
+	key, err := audit.LoadKey(auditKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load audit key: %v", err)
+	}
+
+	return audit.Open(auditLogFile, key)
+}
+
+// Get the name of the person or system running the command
+func currentOperator() string {
+	if auditOperator != "" {
+		return auditOperator
+	}
+
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	return "unknown"
+}
+
+// Add a module that was listed in a manifest to the audit log
+//
+// Does nothing when the audit log is not configured.
+func recordAudit(auditLog *audit.Log, manifest *module.Manifest, m module.Module, hashes map[string]string) error {
+	if auditLog == nil {
+		return nil
+	}
+
+	err := auditLog.Append(audit.Record{
+		Action:   manifest.Action,
+		Module:   m.Path,
+		Version:  m.Version,
+		Hashes:   hashes,
+		Source:   manifest.Source,
+		Target:   manifest.Target,
+		Operator: manifest.Operator,
+		Manifest: manifest.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to audit %v: %v", m, err)
+	}
+
+	return nil
+}
+
+// Write a manifest to a Storage if it lists any modules
+func saveManifest(store module.Storage, manifest *module.Manifest) error {
+	if len(manifest.Modules) == 0 {
+		return nil
+	}
+
+	if err := manifest.Save(store); err != nil {
+		return fmt.Errorf("Failed to save manifest %v: %v", manifest.ID, err)
+	}
+
+	logger.Info("Saved manifest", "manifest", manifest.ID, "modules", len(manifest.Modules))
+	return nil
+}
+
+func auditQuery(self *Command) error {
+	if auditGenKey != "" {
+		if err := audit.GenerateKey(auditGenKey); err != nil {
+			return fmt.Errorf("Failed to create audit key: %v", err)
+		}
+
+		logger.Info("Created audit key", "key", auditGenKey)
+		return nil
+	}
+
+	if auditLogFile == "" {
+		return fmt.Errorf("You must specify the audit log with -log or GOFF_AUDIT_LOG")
+	}
+
+	if auditVerify {
+		if auditKeyFile == "" {
+			return fmt.Errorf("-verify requires a key, set -key or GOFF_AUDIT_KEY")
+		}
+
+		key, err := audit.LoadPublicKey(auditKeyFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load audit key: %v", err)
+		}
+
+		verified, err := audit.Verify(auditLogFile, key)
+		if err != nil {
+			logger.Security("Audit log verification failed", "log", auditLogFile, "verified", verified, "error", err)
+			return fmt.Errorf("Audit log %v failed verification after %v records: %v", auditLogFile, verified, err)
+		}
+
+		logger.Info("Verified audit log", "log", auditLogFile, "records", verified)
+	}
+
+	var since time.Time
+	if auditSince != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, auditSince); err != nil {
+			if since, err = time.Parse("2006-01-02", auditSince); err != nil {
+				return fmt.Errorf("Invalid -since time %q", auditSince)
+			}
+		}
+	}
+
+	records, err := audit.Read(auditLogFile)
+	if err != nil {
+		return fmt.Errorf("Failed to read audit log: %v", err)
+	}
+
+	for _, r := range records {
+		if auditModule != "" {
+			if matched, _ := path.Match(auditModule, r.Module); !matched {
+				continue
+			}
+		}
+
+		if (auditAction != "" && r.Action != auditAction) ||
+			(auditVersion != "" && r.Version != auditVersion) ||
+			(auditUser != "" && r.Operator != auditUser) ||
+			(auditManifest != "" && r.Manifest != auditManifest) ||
+			r.Time.Before(since) {
+			continue
+		}
+
+		if auditJSON {
+			line, _ := json.Marshal(r)
+			fmt.Println(string(line))
+			continue
+		}
+
+		fmt.Printf("%v %v %-10v %v@%v operator=%v manifest=%v %v -> %v\n",
+			r.Seq, r.Time.Format(time.RFC3339), r.Action, r.Module, r.Version,
+			r.Operator, r.Manifest, orNone(r.Source), orNone(r.Target))
+		if len(r.Hashes) > 0 {
+			fmt.Printf("    mod=%v zip=%v\n", orNone(r.Hashes["mod"]), orNone(r.Hashes["zip"]))
+		}
+	}
+
+	return nil
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
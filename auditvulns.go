@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+	"github.com/haboustak/goff/internal/vulndb"
+	"strings"
+	"time"
+)
+
+var (
+	vulnsDb       string
+	vulnsManifest string
+	vulnsJSON     bool
+)
+
+var cmdAuditVulns = &Command{
+	Name: "audit-vulns",
+	Run:  auditVulns,
+	Usage: `Usage:
+    goff [-h] audit-vulns [-vulndb dir] [-manifest id] [-json] module_dir
+
+Report the module versions of a module set that are affected by known
+vulnerabilities
+
+module_dir is a directory, a .tar or .zip bundle of module files, or an
+S3-compatible bucket, such as a serve ROOT_DIR. Every module version is
+checked against the snapshot of the Go vulnerability database written by
+download -vulndb, which is read from module_dir unless -vulndb names
+another module set. No network access is needed. With -manifest, only the
+modules listed in the manifest of a download or upload are checked.
+
+Each affected module version is listed with the vulnerability ID, its
+aliases, the version that fixes it and a summary. goff exits with an error
+when any module version is affected, so a transfer can be rejected before
+it is uploaded.
+
+Options:
+    -h          show this help
+    -json       print each affected module version as a JSON line
+    -manifest   check the modules listed in a manifest
+    -vulndb     module set that holds the vulnerability database
+                (default=module_dir)
+`,
+}
+
+type moduleVuln struct {
+	Path    string
+	Version string
+	ID      string
+	Aliases []string `json:",omitempty"`
+	Summary string   `json:",omitempty"`
+	Fixed   string   `json:",omitempty"`
+}
+
+func init() {
+	cmdAuditVulns.Flags.StringVar(&vulnsDb, "vulndb", "", "module set that holds the vulnerability database")
+	cmdAuditVulns.Flags.StringVar(&vulnsManifest, "manifest", "", "manifest ID of the modules to check")
+	cmdAuditVulns.Flags.BoolVar(&vulnsJSON, "json", false, "print affected modules as JSON lines")
+}
+
+func auditVulns(self *Command) error {
+	args := self.Flags.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("You must specify the module set directory")
+	}
+
+	store, err := module.OpenStorage(args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to open module set %v: %v", args[0], err)
+	}
+
+	dbStore := store
+	if vulnsDb != "" {
+		if dbStore, err = module.OpenStorage(vulnsDb); err != nil {
+			return fmt.Errorf("Failed to open module set %v: %v", vulnsDb, err)
+		}
+	} else {
+		vulnsDb = args[0]
+	}
+
+	if !vulndb.Exists(dbStore) {
+		return fmt.Errorf("No vulnerability database in %v, use download -vulndb", vulnsDb)
+	}
+
+	var modules []module.Module
+	if vulnsManifest != "" {
+		manifest, err := module.LoadManifest(store, vulnsManifest)
+		if err != nil {
+			return fmt.Errorf("Failed to read manifest %v: %v", vulnsManifest, err)
+		}
+
+		for _, m := range manifest.Modules {
+			modules = append(modules, module.Module{Path: m.Path, Version: m.Version})
+		}
+	} else if modules, err = module.StoredModules(store); err != nil {
+		return fmt.Errorf("Failed to read module set %v: %v", args[0], err)
+	}
+
+	return reportVulns(dbStore, modules, !vulnsJSON)
+}
+
+// Print the vulnerabilities that affect a list of module versions
+//
+// Returns an error when any module version is affected.
+func reportVulns(dbStore module.Storage, modules []module.Module, text bool) error {
+	findings, err := vulndb.Scan(dbStore, modules)
+	if err != nil {
+		return err
+	}
+
+	affected := make(map[module.Module]bool)
+	ids := make(map[string]bool)
+	for _, f := range findings {
+		affected[f.Module] = true
+		ids[f.ID] = true
+
+		if !text {
+			line, _ := json.Marshal(moduleVuln{f.Module.Path, f.Module.Version, f.ID, f.Aliases, f.Summary, f.Fixed})
+			fmt.Println(string(line))
+			continue
+		}
+
+		fixed := "fixed=none"
+		if f.Fixed != "" {
+			fixed = "fixed=" + f.Fixed
+		}
+
+		names := append([]string{f.ID}, f.Aliases...)
+		fmt.Printf("%v %v %v %v\n", f.Module, strings.Join(names, ","), fixed, f.Summary)
+	}
+
+	if text {
+		index, err := vulndb.ReadDBIndex(dbStore)
+		if err != nil {
+			return err
+		}
+
+		logger.Info(fmt.Sprintf("Checked %v module versions", len(modules)),
+			"modules", len(modules), "vulndb", index.Modified.Format(time.RFC3339))
+	}
+
+	if len(affected) > 0 {
+		return fmt.Errorf("%v module versions are affected by %v vulnerabilities", len(affected), len(ids))
+	}
+
+	return nil
+}
@@ -2,35 +2,138 @@ package main
 
 import (
 	"fmt"
+	"github.com/haboustak/goff/internal/audit"
 	"github.com/haboustak/goff/internal/module"
 	"github.com/haboustak/goff/internal/task"
+	"github.com/haboustak/goff/internal/vulndb"
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
 	"golang.org/x/mod/sumdb"
 	"net/url"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
-	outDir        string
-	downloadProxy string
+	outDir             string
+	downloadProxy      string
+	downloadToolchains bool
+	toolchainPlatforms string
+	allowRetracted     bool
+	fromMissing        string
+	snapshotVulnDb     bool
+	vulnDbUrl          string
 )
 
 var cmdDownload = &Command{
 	Name: "download",
 	Run:  download,
 	Usage: `Usage:
-    goff [-h] download [-outdir path] [-proxy hostname] modules
+    goff [-h] download [-outdir path] [-proxy hostname] [-toolchain]
+                       [-platforms list] [-allow-retracted]
+                       [-from-missing file] [-policy file]
+                       [-audit-log file -audit-key file] [-operator name]
+                       [-vulndb] [-vulndb-url url] [modules]
 
 Download modules and collect them into a module set
 
+Modules are named path[@query], where query is any version query accepted
+by the go command: latest (default), upgrade, patch, a version (v1.2.3),
+a version prefix (v1.2), a comparison (<v1.3, >=v1.2.0), a branch name or
+a commit hash. upgrade and patch are relative to the highest version of
+the module already in the module set. Queries skip versions retracted by
+the module's author.
+
+-from-missing reads additional modules from a file written by serve -record,
+one per line. The go command probes the parent paths of a module, which are
+recorded too, so entries that do not resolve to a module are skipped with a
+warning.
+
+-policy reads allow and deny rules for module paths and versions from a
+file. Every module version in the build list is checked before it is
+downloaded, and each one that is denied is reported with the reason given
+by its rule. Older versions that are required by go.mod files but not
+selected for the build list are not reported, and the go.mod files of
+denied versions are read without being stored. When any version in the
+build list is denied, only the go.mod files of allowed versions are stored.
+
+    # deny PATTERN [RANGE] [REASON...]
+    # allow PATTERN [RANGE] [REASON...]
+    deny github.com/badcorp/*             unapproved host
+    deny golang.org/x/text <v0.3.8        CVE-2022-32149
+    allow golang.org/x
+    allow github.com/approved/*
+
+PATTERN is a glob matched against the module path and its parent paths.
+RANGE is a comma-separated list of versions, version prefixes and
+comparisons that must all match. A REASON that starts with a version must
+be quoted, like "v2 is unsupported", or it is read as a RANGE. A deny rule
+that matches always wins, and when there are allow rules, a module version
+must match one of them.
+
+License rules are checked against the SPDX IDs of the licenses found in
+each module's zip file before it is stored, see "goff help licenses". A
+module is denied if any of its licenses matches a deny-license rule, and
+when there are allow-license rules, each of its licenses must match one.
+GNU licenses are found as GPL-3.0 and so on, which deny-license rules for
+GPL-3.0-only or GPL-3.0-or-later both match, and which are only allowed when
+both are.
+
+    # deny-license LICENSE [REASON...]
+    # allow-license LICENSE [REASON...]
+    deny-license AGPL-*                   network copyleft
+    allow-license MIT
+    allow-license BSD-*
+    allow-license Apache-2.0
+
+The mod and zip files of each module are validated against the checksum
+database. Zip files must follow the go command's size and file name rules.
+.info files are not in the checksum database, so they must name the
+canonical version that was requested, with a Time that is set, not in the
+future and, for pseudo-versions, not before the time in the version.
+Versions other than pseudo-versions must be listed by the proxy's @v/list.
+Metadata that fails these checks is reported as a security error.
+
+Each download writes a manifest of the collected modules and their hashes to
+the manifests directory of the module set, with the licenses found in them.
+Modules that were not already in the module set are added to the audit log,
+see "goff audit".
+
+-vulndb copies the Go vulnerability database to the vulndb directory of the
+module set, so modules can be scanned without network access. Entries that
+have not changed since the last snapshot are not downloaded again, and no
+modules are required when -vulndb is given. The snapshot can be used
+directly by govulncheck, or served with "goff serve":
+
+    GOVULNDB=file:///path/to/modules/vulndb govulncheck ./...
+
 Options:
-    -h      show this help
-    -outdir directory where modules will be stored (default=./modules)
-    -proxy  hostname of proxy to download modules from (default=go env GOPROXY)
+    -allow-retracted
+                download requested versions even if they have been retracted
+    -audit-log  append collected modules to an audit log
+                (default=GOFF_AUDIT_LOG)
+    -audit-key  key used to sign audit records (default=GOFF_AUDIT_KEY)
+    -from-missing
+                download the modules listed in a serve -record file
+    -h          show this help
+    -operator   name recorded in the audit log (default=GOFF_OPERATOR or the
+                current user)
+    -outdir     directory or .tar archive where modules will be stored
+                (default=./modules)
+    -platforms  comma-separated GOOS/GOARCH list of toolchain targets
+                (default=current platform)
+    -policy     file of module allow and deny rules (default=GOFF_POLICY)
+    -proxy      hostname of proxy to download modules from (default=go env GOPROXY)
+    -toolchain  download the Go toolchain modules required by the go and
+                toolchain directives of the collected modules
+    -vulndb     snapshot the Go vulnerability database into the module set
+    -vulndb-url URL of the vulnerability database
+                (default=GOVULNDB or https://vuln.go.dev)
 `,
 }
 
@@ -42,10 +145,18 @@ type downloadResult struct {
 type downloadRequest struct {
 	Proxy      *url.URL
 	OutDir     string
+	Store      module.Storage
 	BuildList  *module.BuildList
 	Queue      *task.TaskQueue
 	SumDb      *sumdb.Client
 	Downloaded chan downloadResult
+	Toolchain  string
+	Status     map[string]*module.ModuleStatus
+	Retracted  map[module.Module]bool
+	Manifest   *module.Manifest
+	Audit      *audit.Log
+	Policy     *module.Policy
+	sync.Mutex
 }
 
 func init() {
@@ -58,8 +169,22 @@ func init() {
 		proxyHost = strings.SplitN(string(result), ",", 2)[0]
 	}
 
+	// GOVULNDB can also name a local snapshot, which can't be downloaded
+	vulnDbHost := os.Getenv("GOVULNDB")
+	if !strings.HasPrefix(vulnDbHost, "http://") && !strings.HasPrefix(vulnDbHost, "https://") {
+		vulnDbHost = "https://vuln.go.dev"
+	}
+
 	cmdDownload.Flags.StringVar(&outDir, "outdir", "modules", "module set output directory")
 	cmdDownload.Flags.StringVar(&downloadProxy, "proxy", proxyHost, "hostname of module proxy")
+	cmdDownload.Flags.BoolVar(&downloadToolchains, "toolchain", false, "download required Go toolchains")
+	cmdDownload.Flags.StringVar(&toolchainPlatforms, "platforms", runtime.GOOS+"/"+runtime.GOARCH, "toolchain GOOS/GOARCH targets")
+	cmdDownload.Flags.BoolVar(&allowRetracted, "allow-retracted", false, "download retracted versions")
+	cmdDownload.Flags.StringVar(&fromMissing, "from-missing", "", "file listing modules to download")
+	cmdDownload.Flags.BoolVar(&snapshotVulnDb, "vulndb", false, "snapshot the vulnerability database")
+	cmdDownload.Flags.StringVar(&vulnDbUrl, "vulndb-url", vulnDbHost, "URL of the vulnerability database")
+	addAuditFlags(&cmdDownload.Flags)
+	addPolicyFlag(&cmdDownload.Flags)
 }
 
 func proxyUrl(hostname string) *url.URL {
@@ -80,33 +205,158 @@ func proxyUrl(hostname string) *url.URL {
 	return host
 }
 
+// Find the highest version of a module already collected in the module set
+//
+// This is the current version used by upgrade and patch queries
+func currentVersion(store module.Storage, m module.Module) string {
+	versions, _ := module.StoredVersions(store, m.Path)
+
+	current := ""
+	for _, version := range versions {
+		if current == "" || semver.Compare(version, current) > 0 {
+			current = version
+		}
+	}
+
+	return current
+}
+
+// Report deprecated modules and retracted versions
+//
+// Retracted versions are an error for modules requested by the user, unless
+// -allow-retracted is set. Retracted dependencies are reported, but are still
+// downloaded because the go command will use them.
+func checkStatus(req *downloadRequest, m module.Module, requested bool) error {
+	req.Lock()
+	status, checked := req.Status[m.Path]
+	req.Unlock()
+
+	if !checked {
+		var err error
+		if status, err = m.Status(req.Proxy); err != nil {
+			status = nil
+		}
+
+		req.Lock()
+		_, checked = req.Status[m.Path]
+		req.Status[m.Path] = status
+		req.Unlock()
+
+		if status != nil && status.Deprecated != "" && !checked {
+			logger.Warn("Module is deprecated", "module", m.Path, "deprecated", status.Deprecated)
+		}
+	}
+
+	retracted, rationale := status.IsRetracted(m.Version)
+	if !retracted {
+		return nil
+	}
+
+	if rationale == "" {
+		rationale = "no rationale given"
+	}
+
+	if requested && !allowRetracted {
+		return fmt.Errorf("Version %v is retracted (%v). Use -allow-retracted to download it anyway", m, rationale)
+	}
+
+	req.Lock()
+	reported := req.Retracted[m]
+	req.Retracted[m] = true
+	req.Unlock()
+
+	if reported {
+		return nil
+	}
+
+	logger.Warn("Version is retracted", "module", m, "rationale", rationale)
+	return nil
+}
+
+// Check that a module version is listed by the proxy's @v/list
+//
+// The list is usually read with the status of the module. Modules without a
+// status, such as toolchain modules, which are not in the build list, have
+// their list read again.
+func checkListed(req *downloadRequest, m module.Module) error {
+	req.Lock()
+	status := req.Status[m.Path]
+	req.Unlock()
+
+	if status != nil {
+		return module.CheckListed(m, status.Versions)
+	}
+
+	if module.IsPseudoVersion(m.Version) {
+		return nil
+	}
+
+	versions, err := m.Versions(req.Proxy)
+	if err != nil {
+		return fmt.Errorf("Failed to list versions of %v: %v", m.Path, err)
+	}
+
+	return module.CheckListed(m, versions)
+}
+
 func updateBuildList(req *downloadRequest, m module.Module) error {
 	// only download a module once
 	if !req.BuildList.Visit(m) {
 		return nil
 	}
 
+	// Versions are checked against the policy once the build list is
+	// complete, but the requirements of modules that are denied at every
+	// version are not visited
+	if req.Policy.CheckPath(m.Path) != nil {
+		return nil
+	}
+
+	if err := checkStatus(req, m, false); err != nil {
+		return err
+	}
+
+	// The requirements of denied versions are still visited, because they
+	// can be replaced by allowed versions, but their go.mod files are not
+	// stored in the module set
+	store := req.Store
+	if req.Policy.Check(m) != nil {
+		store = module.NewMemoryStorage()
+	}
+
 	modFile := m.ModuleFile()
-	err := modFile.Download(req.Proxy, req.OutDir, req.SumDb)
+	err := modFile.Download(req.Proxy, store, req.SumDb)
 	if err != nil {
 		return fmt.Errorf("Failed to download %s gomod file: %v", m.String(), err)
 	}
 
-	modFilePath := path.Join(req.OutDir, modFile.FilePath)
-	modFileBytes, err := os.ReadFile(modFilePath)
+	modFileBytes, err := module.ReadFile(store, modFile.FilePath)
 	if err != nil {
-		return fmt.Errorf("Failed to read file %s", modFilePath)
+		return fmt.Errorf("Failed to read file %s: %v", modFile.FilePath, err)
 	}
 
 	// Visit all dependencies listed in the go.mod file
 	modDetails, err := modfile.ParseLax(m.Path, modFileBytes, nil)
 	if err != nil {
-		os.Remove(modFilePath)
 		return fmt.Errorf("Failed to parse gomod file for %s: %v", m.String(), err)
 	}
 
+	// Track the newest toolchain required by any module in the build list
+	toolchain := module.ParseGoDirectives(modFileBytes).ToolchainName()
+	if toolchain != "" {
+		req.Lock()
+		if req.Toolchain == "" || module.CompareGoVersions(toolchain[2:], req.Toolchain[2:]) > 0 {
+			req.Toolchain = toolchain
+		}
+		req.Unlock()
+	}
+
 	for _, r := range modDetails.Require {
 		depModule := module.New(r.Mod)
+		if err := depModule.Check(); err != nil {
+			return fmt.Errorf("Invalid requirement in gomod file for %s: %v", m.String(), err)
+		}
+
 		req.Queue.Append(func() error {
 			return updateBuildList(req, depModule)
 		})
@@ -115,13 +365,68 @@ func updateBuildList(req *downloadRequest, m module.Module) error {
 	return nil
 }
 
-func download(self *Command) error {
+// Add a downloaded module to the manifest, and to the audit log if it was
+// not already in the module set
+func recordDownload(req *downloadRequest, m module.Module, isNew bool) error {
+	hashes, err := m.Hashes(req.Store)
+	if err != nil {
+		return err
+	}
+
+	licenses, err := m.Licenses(req.Store)
+	if err != nil {
+		return err
+	}
+
+	req.Manifest.Add(m, hashes, licenses)
+	if !isNew {
+		return nil
+	}
+
+	return recordAudit(req.Audit, req.Manifest, m, hashes)
+}
+
+func download(self *Command) (err error) {
 	moduleNames := self.Flags.Args()
-	if len(moduleNames) < 1 {
+	nArgs := len(moduleNames)
+	if fromMissing != "" {
+		missing, err := module.ReadModuleList(fromMissing)
+		if err != nil {
+			return fmt.Errorf("Failed to read missing modules: %v", err)
+		}
+		moduleNames = append(moduleNames, missing...)
+	}
+
+	if len(moduleNames) < 1 && !snapshotVulnDb {
 		return fmt.Errorf("You must provide one or more modules to download")
 	}
 
-	for _, name := range moduleNames {
+	store, err := module.CreateStorage(outDir)
+	if err != nil {
+		return fmt.Errorf("Failed to open module set %v: %v", outDir, err)
+	}
+
+	policy, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+
+	auditLog, err := openAuditLog()
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+
+	manifest := module.NewManifest("download", currentOperator(), proxyUrl(downloadProxy).String(), outDir)
+	defer func() {
+		if saveErr := saveManifest(store, manifest); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	for i, name := range moduleNames {
 		m, err := module.Parse(name)
 		if err != nil {
 			return err
@@ -129,19 +434,26 @@ func download(self *Command) error {
 
 		req := new(downloadRequest)
 		req.OutDir = outDir
+		req.Store = store
+		req.Manifest = manifest
+		req.Audit = auditLog
+		req.Policy = policy
 		req.Proxy = proxyUrl(downloadProxy)
 		req.Queue = task.NewTaskQueue(0)
 		req.Downloaded = make(chan downloadResult)
 
 		dbUrl, _ := url.Parse("https://sum.golang.org")
-		req.SumDb = module.NewClient(dbUrl)
-
-		// Use the latest version if a specific version was not specified
-		if m.Version == "" {
-			m.Version, err = m.LatestVersion(req.Proxy)
-			if err != nil {
-				return fmt.Errorf("Failed to get latest version for module %v: %v\n", m.String(), err)
-			}
+		req.SumDb = module.NewClient(dbUrl, logger)
+
+		// Resolve version queries (latest, upgrade, patch, v1.2, <v1.3, branch
+		// names and commit hashes) to a canonical version or pseudo-version
+		query := m.Version
+		m.Version, err = m.Query(req.Proxy, currentVersion(req.Store, m))
+		if err != nil && i >= nArgs {
+			logger.Warn("Skipping missing module", "module", name, "error", err)
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Failed to resolve version query %v@%v: %v", m.Path, query, err)
 		}
 
 		// Get the true capitalization of this module's path from the proxy
@@ -151,7 +463,13 @@ func download(self *Command) error {
 			return err
 		}
 
-		fmt.Printf("Collecting requirements for %v\n", m)
+		req.Status = make(map[string]*module.ModuleStatus)
+		req.Retracted = make(map[module.Module]bool)
+		if err := checkStatus(req, m, true); err != nil {
+			return err
+		}
+
+		logger.Info("Collecting requirements", "module", m)
 
 		// recursively build the list of modules required to build this module
 		req.BuildList = module.NewBuildList()
@@ -163,7 +481,32 @@ func download(self *Command) error {
 			return req.Queue.LastError
 		}
 
+		// Only the versions selected for the build list are checked, a go.mod
+		// file can require a denied version that is replaced by a newer one
 		deps := req.BuildList.All()
+		var violations []error
+		for _, dependency := range deps {
+			if err := req.Policy.Check(dependency); err != nil {
+				violations = append(violations, err)
+			}
+		}
+
+		if err := reportViolations(violations); err != nil {
+			return err
+		}
+
+		// Toolchain modules are collected separately, the build list only
+		// keeps the newest version of each module path
+		if downloadToolchains && req.Toolchain != "" {
+			platforms := strings.Split(toolchainPlatforms, ",")
+			toolchains, err := module.ToolchainModules(req.Proxy, req.Toolchain, platforms)
+			if err != nil {
+				return fmt.Errorf("Failed to find toolchain %v: %v", req.Toolchain, err)
+			}
+
+			logger.Info("Collecting toolchain", "toolchain", req.Toolchain, "platforms", toolchainPlatforms)
+			deps = append(deps, toolchains...)
+		}
 		nDeps := len(deps)
 
 		statusDone := make(chan struct{})
@@ -171,9 +514,13 @@ func download(self *Command) error {
 			next := 0
 			for result := range req.Downloaded {
 				next++
-				fmt.Printf("%v/%v: %v\n", next, nDeps, result.Module)
-				if result.Error != nil {
-					fmt.Println(result.Error)
+				progress := fmt.Sprintf("%v/%v", next, nDeps)
+				if _, invalid := result.Error.(*module.InfoError); invalid {
+					logger.Security("Proxy returned invalid module metadata", "module", result.Module, "progress", progress, "error", result.Error)
+				} else if result.Error != nil {
+					logger.Error("Failed to download module", "module", result.Module, "progress", progress, "error", result.Error)
+				} else {
+					logger.Info("Downloaded module", "module", result.Module, "progress", progress)
 				}
 			}
 			close(statusDone)
@@ -183,7 +530,14 @@ func download(self *Command) error {
 		for _, dependency := range deps {
 			mod := dependency
 			req.Queue.Append(func() error {
-				err := mod.Download(req.Proxy, req.OutDir, req.SumDb)
+				_, missing := req.Store.Stat(mod.ZipFile().FilePath)
+				err := checkListed(req, mod)
+				if err == nil {
+					err = mod.Download(req.Proxy, req.Store, req.SumDb, req.Policy)
+				}
+				if err == nil {
+					err = recordDownload(req, mod, missing != nil)
+				}
 				req.Downloaded <- downloadResult{mod, err}
 				return err
 			})
@@ -205,8 +559,37 @@ func download(self *Command) error {
 			modSuffix = "s"
 		}
 
-		fmt.Printf("Downloaded %v module%v to %v%v\n", nDeps, modSuffix, pathPrefix, req.OutDir)
+		logger.Info(fmt.Sprintf("Downloaded %v module%v to %v%v", nDeps, modSuffix, pathPrefix, req.OutDir),
+			"modules", nDeps, "outdir", req.OutDir)
+	}
+
+	if snapshotVulnDb {
+		return downloadVulnDb(store)
+	}
+
+	return nil
+}
+
+// Copy the vulnerability database to the module set
+func downloadVulnDb(store module.Storage) error {
+	dbUrl, err := url.Parse(vulnDbUrl)
+	if err != nil || (dbUrl.Scheme != "http" && dbUrl.Scheme != "https") {
+		return fmt.Errorf("Invalid vulnerability database URL %v", vulnDbUrl)
+	}
+
+	logger.Info("Collecting vulnerability database", "url", dbUrl)
+
+	downloaded, err := vulndb.Snapshot(dbUrl, store)
+	if err != nil {
+		return fmt.Errorf("Failed to snapshot vulnerability database: %v", err)
+	}
+
+	index, err := vulndb.ReadDBIndex(store)
+	if err != nil {
+		return fmt.Errorf("Failed to read vulnerability database: %v", err)
 	}
 
+	logger.Info(fmt.Sprintf("Downloaded %v vulnerability entries to %v", downloaded, filepath.Join(outDir, vulndb.Dir)),
+		"entries", downloaded, "modified", index.Modified.Format(time.RFC3339))
 	return nil
 }
@@ -2,4 +2,7 @@ module github.com/haboustak/goff
 
 go 1.16
 
-require golang.org/x/mod v0.5.1
+require (
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
+	golang.org/x/mod v0.5.1
+)
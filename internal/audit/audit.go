@@ -0,0 +1,337 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A record of a module version entering or leaving a module set or proxy
+type Record struct {
+	Seq      int64             `json:"seq"`
+	Time     time.Time         `json:"time"`
+	Action   string            `json:"action"`
+	Module   string            `json:"module"`
+	Version  string            `json:"version"`
+	Hashes   map[string]string `json:"hashes,omitempty"`
+	Source   string            `json:"source,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	Operator string            `json:"operator,omitempty"`
+	Manifest string            `json:"manifest,omitempty"`
+
+	// Hex SHA-256 of the previous line of the log
+	Prev string `json:"prev"`
+
+	// Base64 ed25519 signature of the record without its signature
+	Signature string `json:"sig,omitempty"`
+}
+
+// An append-only log of signed records
+//
+// Each record holds the hash of the line before it, so records cannot be
+// changed, removed or reordered without breaking the chain, and is signed
+// so that records cannot be added without the key.
+//
+// Several processes can append to the same log. Each append locks the file
+// and reads the records added since the last one before signing.
+type Log struct {
+	path string
+	key  ed25519.PrivateKey
+	file *os.File
+	seq  int64
+	prev string
+	size int64
+	sync.Mutex
+}
+
+// Open or create an audit log for appending records signed with key
+func Open(logPath string, key ed25519.PrivateKey) (*Log, error) {
+	file, err := os.OpenFile(logPath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{path: logPath, key: key, file: file}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to lock audit log %v: %v", logPath, err)
+	}
+	defer unlockFile(file)
+
+	if err := l.readNew(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Read the records appended since the log was last read, the caller must
+// hold the file lock
+func (l *Log) readNew() error {
+	info, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == l.size {
+		return nil
+	}
+
+	if info.Size() < l.size {
+		return fmt.Errorf("Audit log %v was truncated", l.path)
+	}
+
+	err = readLines(io.NewSectionReader(l.file, l.size, info.Size()-l.size), func(line []byte) error {
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		l.seq = r.Seq
+		l.prev = lineHash(line)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to read audit log %v: %v", l.path, err)
+	}
+
+	l.size = info.Size()
+	return nil
+}
+
+// Sign a record and add it to the end of the log
+//
+// The sequence number, previous hash and signature are set by the log. The
+// time is set if it is empty.
+func (l *Log) Append(r Record) error {
+	l.Lock()
+	defer l.Unlock()
+
+	if err := lockFile(l.file); err != nil {
+		return fmt.Errorf("Failed to lock audit log %v: %v", l.path, err)
+	}
+	defer unlockFile(l.file)
+
+	// Another process may have appended records since the last append
+	if err := l.readNew(); err != nil {
+		return err
+	}
+
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	r.Seq = l.seq + 1
+	r.Prev = l.prev
+	r.Signature = ""
+
+	unsigned, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.key, unsigned))
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Failed to write audit log %v: %v", l.path, err)
+	}
+
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.seq = r.Seq
+	l.prev = lineHash(line)
+	l.size += int64(len(line)) + 1
+	return nil
+}
+
+// Close the log
+func (l *Log) Close() error {
+	return l.file.Close()
+}
+
+// Read every record of an audit log
+func Read(logPath string) ([]Record, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []Record
+	err = readLines(file, func(line []byte) error {
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+
+	return records, err
+}
+
+// Check the hash chain and signatures of every record of an audit log
+//
+// Returns the number of verified records, and an error describing the first
+// record that fails verification.
+func Verify(logPath string, key ed25519.PublicKey) (int, error) {
+	file, err := os.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	verified := 0
+	prev := ""
+	err = readLines(file, func(line []byte) error {
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("record %v is malformed: %v", verified+1, err)
+		}
+
+		if r.Seq != int64(verified+1) {
+			return fmt.Errorf("record %v has sequence number %v", verified+1, r.Seq)
+		}
+
+		if r.Prev != prev {
+			return fmt.Errorf("record %v does not follow record %v", r.Seq, verified)
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(r.Signature)
+		if err != nil {
+			return fmt.Errorf("record %v has a malformed signature", r.Seq)
+		}
+
+		r.Signature = ""
+		unsigned, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		if !ed25519.Verify(key, unsigned, signature) {
+			return fmt.Errorf("record %v has an invalid signature", r.Seq)
+		}
+
+		verified++
+		prev = lineHash(line)
+		return nil
+	})
+
+	return verified, err
+}
+
+// Create a new signing key and write it to a PEM file readable only by the
+// owner
+func GenerateKey(keyPath string) error {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// Read an ed25519 private key from a PEM file
+func LoadKey(keyPath string) (ed25519.PrivateKey, error) {
+	key, err := loadPEMKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%v is not an ed25519 private key", keyPath)
+	}
+
+	return privateKey, nil
+}
+
+// Read an ed25519 public key, or the public half of a private key, from a
+// PEM file
+func LoadPublicKey(keyPath string) (ed25519.PublicKey, error) {
+	key, err := loadPEMKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k.Public().(ed25519.PublicKey), nil
+	case ed25519.PublicKey:
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("%v is not an ed25519 key", keyPath)
+}
+
+func loadPEMKey(keyPath string) (interface{}, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found in %v", keyPath)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	return nil, fmt.Errorf("Unsupported PEM block %q in %v", block.Type, keyPath)
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// Call fn with every non-empty line of a file
+func readLines(reader io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Generate a signing key in a temporary directory
+func testKey(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
+	keyPath := filepath.Join(t.TempDir(), "audit.key")
+	if err := GenerateKey(keyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := LoadKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := LoadPublicKey(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, public
+}
+
+// Write a log of count records and return its path
+func testLog(t *testing.T, key ed25519.PrivateKey, count int) string {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(logPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < count; i++ {
+		err := l.Append(Record{Action: "download", Module: "example.com/a", Version: fmt.Sprintf("v1.0.%v", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return logPath
+}
+
+func TestAppendVerify(t *testing.T) {
+	key, public := testKey(t)
+	logPath := testLog(t, key, 3)
+
+	// A reopened log continues the chain
+	l, err := Open(logPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Record{Action: "upload", Module: "example.com/b", Version: "v2.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	verified, err := Verify(logPath, public)
+	if err != nil || verified != 4 {
+		t.Fatalf("Verify = %v, %v, expected 4 records", verified, err)
+	}
+
+	records, err := Read(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, r := range records {
+		if r.Seq != int64(i+1) || r.Time.IsZero() || r.Signature == "" {
+			t.Errorf("Record %v = %+v, expected sequence number %v, a time and a signature", i, r, i+1)
+		}
+	}
+	if records[0].Prev != "" || records[3].Module != "example.com/b" {
+		t.Errorf("Read returned unexpected records %+v", records)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	key, public := testKey(t)
+	_, otherPublic := testKey(t)
+	logPath := testLog(t, key, 3)
+
+	original, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(original, []byte("\n"))
+
+	tests := []struct {
+		name     string
+		contents []byte
+		key      ed25519.PublicKey
+		verified int
+		err      string
+	}{
+		{"changed version", bytes.Replace(original, []byte(`"v1.0.1"`), []byte(`"v1.0.9"`), 1), public, 1, "record 2 has an invalid signature"},
+		{"removed record", bytes.Join([][]byte{lines[0], lines[2]}, nil), public, 1, "record 2 has sequence number 3"},
+		{"swapped records", bytes.Join([][]byte{lines[1], lines[0], lines[2]}, nil), public, 0, "record 1 has sequence number 2"},
+		{"malformed record", append(append([]byte{}, lines[0]...), []byte("{\n")...), public, 1, "record 2 is malformed"},
+		{"other key", original, otherPublic, 0, "record 1 has an invalid signature"},
+	}
+
+	for _, test := range tests {
+		if err := os.WriteFile(logPath, test.contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		verified, err := Verify(logPath, test.key)
+		if verified != test.verified || err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("Verify of %v = %v, %v, expected %v records and an error containing %q",
+				test.name, verified, err, test.verified, test.err)
+		}
+	}
+}
+
+func TestVerifyResignedRecord(t *testing.T) {
+	key, public := testKey(t)
+	logPath := testLog(t, key, 2)
+
+	// A record that is changed and signed again breaks the hash chain of the
+	// record after it
+	records, err := Read(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewritten := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(rewritten, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records[0].Version = "v1.0.9"
+	if err := l.Append(records[0]); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	contents, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := os.ReadFile(rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := bytes.SplitAfter(contents, []byte("\n"))[1]
+	if err := os.WriteFile(logPath, append(forged, second...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if verified, err := Verify(logPath, public); verified != 1 || err == nil || !strings.Contains(err.Error(), "record 2 does not follow record 1") {
+		t.Errorf("Verify = %v, %v, expected the second record to break the chain", verified, err)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package audit
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWriters(t *testing.T) {
+	key, public := testKey(t)
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+
+	// Each Log has its own file lock, like two processes appending to the
+	// same log
+	const writers, appends = 2, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		l, err := Open(logPath, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		wg.Add(1)
+		go func(l *Log) {
+			defer wg.Done()
+			for j := 0; j < appends; j++ {
+				if err := l.Append(Record{Action: "download", Module: "example.com/a", Version: "v1.0.0"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	if verified, err := Verify(logPath, public); err != nil || verified != writers*appends {
+		t.Errorf("Verify = %v, %v, expected %v records", verified, err, writers*appends)
+	}
+}
@@ -0,0 +1,280 @@
+package license
+
+import (
+	"archive/zip"
+	"embed"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// IDs used when a module's license cannot be identified
+const (
+	// The module has no license files
+	None = "NONE"
+
+	// The module has license files that do not match the corpus
+	NoAssertion = "NOASSERTION"
+)
+
+// The smallest fraction of a license's text that must be found in a file
+// for the file to match it
+const MinCoverage = 0.8
+
+// Files larger than this are not scanned
+const maxFileSize = 1 << 20
+
+// The standard text of common licenses from the SPDX License List, named by
+// their SPDX ID
+//
+// The GNU licenses are named by their family, like GPL-3.0, see Forms.
+//
+//go:embed corpus/*.txt
+var corpusFiles embed.FS
+
+// GNU license families, whose license text is the same under their -only and
+// -or-later IDs
+//
+// Whether a module can be used under a later version is stated in its
+// notices rather than the license text, so a license file that matches one
+// of these is ambiguous and is reported by its family ID.
+var families = map[string]bool{
+	"AGPL-3.0": true,
+	"GPL-2.0":  true,
+	"GPL-3.0":  true,
+	"LGPL-2.1": true,
+	"LGPL-3.0": true,
+}
+
+type template struct {
+	id      string
+	phrases map[string]bool
+}
+
+var corpus []template
+
+func init() {
+	entries, _ := corpusFiles.ReadDir("corpus")
+	for _, entry := range entries {
+		text, _ := corpusFiles.ReadFile(path.Join("corpus", entry.Name()))
+		corpus = append(corpus, template{
+			id:      strings.TrimSuffix(entry.Name(), ".txt"),
+			phrases: phrases(text),
+		})
+	}
+}
+
+// A license identified in a file
+type Match struct {
+	// SPDX license ID, the family ID of a GNU license, or NoAssertion
+	ID string
+
+	// Path of the file relative to the module root
+	File string `json:",omitempty"`
+
+	// Fraction of the license text found in the file
+	Coverage float64
+}
+
+// Identify the licenses in a license file
+//
+// A file can hold more than one license. Files that do not match any
+// license of the corpus return a single NoAssertion match.
+func Classify(text []byte) []Match {
+	doc := phrases(text)
+	if len(doc) == 0 {
+		return nil
+	}
+
+	type candidate struct {
+		template
+		found []string
+	}
+
+	var candidates []candidate
+	for _, t := range corpus {
+		var found []string
+		for phrase := range t.phrases {
+			if doc[phrase] {
+				found = append(found, phrase)
+			}
+		}
+
+		if float64(len(found)) >= MinCoverage*float64(len(t.phrases)) {
+			candidates = append(candidates, candidate{t, found})
+		}
+	}
+
+	coverage := func(c candidate) float64 {
+		return float64(len(c.found)) / float64(len(c.template.phrases))
+	}
+
+	// Licenses that contain the text of other licenses, like BSD-3-Clause
+	// and BSD-2-Clause, match the same file. The license with the most text
+	// in the file wins, and other licenses only match if most of their text
+	// is not part of a better match.
+	sort.Slice(candidates, func(a, b int) bool {
+		return len(candidates[a].found) > len(candidates[b].found)
+	})
+
+	var matches []Match
+	claimed := make(map[string]bool)
+	for _, c := range candidates {
+		unclaimed := 0
+		for _, phrase := range c.found {
+			if !claimed[phrase] {
+				unclaimed++
+			}
+		}
+
+		if unclaimed*2 < len(c.found) {
+			continue
+		}
+
+		for _, phrase := range c.found {
+			claimed[phrase] = true
+		}
+		matches = append(matches, Match{ID: c.id, Coverage: coverage(c)})
+	}
+
+	if len(matches) == 0 {
+		matches = append(matches, Match{ID: NoAssertion})
+	}
+
+	return matches
+}
+
+// Get the SPDX IDs a matched license ID can stand for
+//
+// The family ID of a GNU license, like GPL-3.0, stands for both its -only
+// and -or-later IDs. Any other ID only stands for itself.
+func Forms(id string) []string {
+	if families[id] {
+		return []string{id + "-only", id + "-or-later"}
+	}
+
+	return []string{id}
+}
+
+// Test if a file name is used for license files, such as LICENSE,
+// LICENSE.md, LICENSE-APACHE or COPYING
+func IsLicenseFile(name string) bool {
+	upper := strings.ToUpper(path.Base(name))
+	if strings.HasSuffix(upper, ".GO") {
+		return false
+	}
+
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "UNLICENSE", "COPYRIGHT"} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Identify the licenses of a module from the license files in the root of
+// its zip file
+//
+// Returns a single None match when the module has no license files.
+func ScanZip(contents io.ReaderAt, size int64) ([]Match, error) {
+	archive, err := zip.NewReader(contents, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []Match
+	for _, file := range archive.File {
+		// Files are named path@version/name, and module paths cannot
+		// contain an @
+		at := strings.Index(file.Name, "@")
+		if at < 0 {
+			continue
+		}
+
+		parts := strings.SplitN(file.Name[at:], "/", 2)
+		if len(parts) != 2 || strings.Contains(parts[1], "/") || !IsLicenseFile(parts[1]) {
+			continue
+		}
+
+		if file.UncompressedSize64 > maxFileSize {
+			continue
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		text, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range Classify(text) {
+			match.File = parts[1]
+			matches = append(matches, match)
+		}
+	}
+
+	if len(matches) == 0 {
+		matches = append(matches, Match{ID: None})
+	}
+
+	return matches, nil
+}
+
+// Get the sorted, unique license IDs of a list of matches
+//
+// Returns None when there are no matches.
+func IDs(matches []Match) []string {
+	if len(matches) == 0 {
+		return []string{None}
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, match := range matches {
+		if !seen[match.ID] {
+			seen[match.ID] = true
+			ids = append(ids, match.ID)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Split normalized text into overlapping three word phrases
+//
+// Text is compared without case, punctuation or copyright lines, which
+// name the authors rather than the license.
+func phrases(text []byte) map[string]bool {
+	var words []string
+	for _, line := range strings.Split(strings.ToLower(string(text)), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "copyright") || strings.HasPrefix(line, "all rights reserved") {
+			continue
+		}
+
+		words = append(words, strings.FieldsFunc(line, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+
+	for i, word := range words {
+		if word == "licence" {
+			words[i] = "license"
+		}
+	}
+
+	set := make(map[string]bool)
+	for i := 0; i+3 <= len(words); i++ {
+		set[strings.Join(words[i:i+3], " ")] = true
+	}
+
+	return set
+}
@@ -0,0 +1,71 @@
+package license
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Read the text of a license from the corpus
+func corpusText(t *testing.T, id string) []byte {
+	text, err := corpusFiles.ReadFile("corpus/" + id + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return text
+}
+
+func TestClassify(t *testing.T) {
+	gpl := corpusText(t, "GPL-3.0")
+	laterNotice := append([]byte("This program is free software: you can redistribute it and/or modify "+
+		"it under the terms of the GNU General Public License as published by the Free Software "+
+		"Foundation, either version 3 of the License, or (at your option) any later version.\n\n"), gpl...)
+
+	tests := []struct {
+		name string
+		text []byte
+		ids  []string
+	}{
+		{"MIT", corpusText(t, "MIT"), []string{"MIT"}},
+		{"BSD-3-Clause", corpusText(t, "BSD-3-Clause"), []string{"BSD-3-Clause"}},
+		{"GPL-3.0", gpl, []string{"GPL-3.0"}},
+		{"GPL-3.0 with an -or-later notice", laterNotice, []string{"GPL-3.0"}},
+		{"LGPL-2.1", corpusText(t, "LGPL-2.1"), []string{"LGPL-2.1"}},
+		{"AGPL-3.0", corpusText(t, "AGPL-3.0"), []string{"AGPL-3.0"}},
+		{"unknown", []byte("You may do whatever the author of this file allows you to do with it."), []string{NoAssertion}},
+	}
+
+	for _, test := range tests {
+		var ids []string
+		for _, match := range Classify(test.text) {
+			ids = append(ids, match.ID)
+		}
+
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("Classify(%v) = %v, expected %v", test.name, ids, test.ids)
+		}
+	}
+
+	if matches := Classify(nil); matches != nil {
+		t.Errorf("Classify of an empty file = %v, expected no matches", matches)
+	}
+}
+
+func TestForms(t *testing.T) {
+	tests := []struct {
+		id    string
+		forms []string
+	}{
+		{"GPL-3.0", []string{"GPL-3.0-only", "GPL-3.0-or-later"}},
+		{"LGPL-2.1", []string{"LGPL-2.1-only", "LGPL-2.1-or-later"}},
+		{"GPL-3.0-only", []string{"GPL-3.0-only"}},
+		{"MIT", []string{"MIT"}},
+		{NoAssertion, []string{NoAssertion}},
+	}
+
+	for _, test := range tests {
+		if forms := Forms(test.id); !reflect.DeepEqual(forms, test.forms) {
+			t.Errorf("Forms(%v) = %v, expected %v", test.id, forms, test.forms)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+// Log levels in increasing order of severity
+//
+// Security is used for checksum database and verification failures that
+// may indicate tampering.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelSecurity
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR", "SECURITY"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelSecurity {
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// Read a level from its name, ignoring case
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("Unknown log level %q", name)
+}
+
+type Format string
+
+// Log line formats
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// Read a log format from its name
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case FormatText, FormatJSON:
+		return Format(name), nil
+	}
+
+	return FormatText, fmt.Errorf("Unknown log format %q", name)
+}
+
+// A Logger writes messages with key-value fields as text or JSON lines
+//
+// Messages below Error are written to Out. Errors and security errors are
+// written to Err.
+type Logger struct {
+	Out    io.Writer
+	Err    io.Writer
+	Format Format
+	Level  Level
+
+	fields []interface{}
+	mu     *sync.Mutex
+}
+
+// Create a Logger that writes messages at level or above
+func New(out io.Writer, errOut io.Writer, format Format, level Level) *Logger {
+	return &Logger{
+		Out:    out,
+		Err:    errOut,
+		Format: format,
+		Level:  level,
+		mu:     new(sync.Mutex),
+	}
+}
+
+// Create a text Logger that writes to stdout and stderr
+func Default() *Logger {
+	return New(os.Stdout, os.Stderr, FormatText, LevelInfo)
+}
+
+// Create a Logger that adds key-value fields to every message
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	child := *l
+	child.fields = append(append([]interface{}{}, l.fields...), keyvals...)
+	return &child
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(LevelDebug, msg, keyvals...)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(LevelInfo, msg, keyvals...)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(LevelWarn, msg, keyvals...)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+}
+
+// Log a failed security check, which is never filtered by level
+func (l *Logger) Security(msg string, keyvals ...interface{}) {
+	l.Log(LevelSecurity, msg, keyvals...)
+}
+
+// Write a message with alternating keys and values
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.Level && level != LevelSecurity {
+		return
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(missing)")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var line string
+	if l.Format == FormatJSON {
+		line = jsonLine(now, level, msg, fields)
+	} else {
+		line = textLine(now, level, msg, fields)
+	}
+
+	out := l.Out
+	if level >= LevelError {
+		out = l.Err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(out, line)
+}
+
+func textLine(now string, level Level, msg string, fields []interface{}) string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%v %-5v %v", now, level, msg)
+	for i := 0; i < len(fields); i += 2 {
+		fmt.Fprintf(&line, " %v=%v", fields[i], quoteText(formatValue(fields[i+1])))
+	}
+	line.WriteString("\n")
+
+	return line.String()
+}
+
+func jsonLine(now string, level Level, msg string, fields []interface{}) string {
+	var line strings.Builder
+	line.WriteString("{")
+	writeJSONField(&line, "time", now)
+	line.WriteString(",")
+	writeJSONField(&line, "level", level.String())
+	line.WriteString(",")
+	writeJSONField(&line, "msg", msg)
+	for i := 0; i < len(fields); i += 2 {
+		line.WriteString(",")
+		writeJSONField(&line, fmt.Sprint(fields[i]), fields[i+1])
+	}
+	line.WriteString("}\n")
+
+	return line.String()
+}
+
+func writeJSONField(line *strings.Builder, key string, value interface{}) {
+	keyBytes, _ := json.Marshal(key)
+	line.Write(keyBytes)
+	line.WriteString(":")
+
+	switch value.(type) {
+	case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+	default:
+		value = formatValue(value)
+	}
+
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		valueBytes, _ = json.Marshal(fmt.Sprint(value))
+	}
+	line.Write(valueBytes)
+}
+
+// Convert a field value to a string
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+
+	return fmt.Sprint(value)
+}
+
+// Quote text values that contain spaces, quotes or newlines
+func quoteText(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return fmt.Sprintf("%q", value)
+	}
+	return value
+}
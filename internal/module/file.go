@@ -1,14 +1,17 @@
 package module
 
 import (
+	"archive/zip"
 	"fmt"
+	"golang.org/x/mod/module"
 	"golang.org/x/mod/sumdb"
 	"golang.org/x/mod/sumdb/dirhash"
+	modzip "golang.org/x/mod/zip"
 	"io"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
+	"strings"
 )
 
 type ModuleFileType string
@@ -45,76 +48,184 @@ func NewModuleFile(m Module, fileType ModuleFileType) ModuleFile {
 	return file
 }
 
+// Get the version of a module from the name of one of its files
+func FileVersion(fileName string, fileType ModuleFileType) (string, error) {
+	if !strings.HasSuffix(fileName, string(fileType)) {
+		return "", fmt.Errorf("File %v is not a %v file", fileName, fileType)
+	}
+
+	escaped := strings.TrimSuffix(fileName, string(fileType))
+	return module.UnescapeVersion(escaped)
+}
+
 // Download a ModuleFile from a proxy
-func (f ModuleFile) Download(proxyUrl *url.URL, outdir string, db *sumdb.Client) error {
-	filePath := path.Join(outdir, f.FilePath)
+//
+// The file is validated against the checksum database before it is added
+// to the Storage. Files that are already stored are not downloaded again.
+func (f ModuleFile) Download(proxyUrl *url.URL, store Storage, db *sumdb.Client) error {
+	return f.download(proxyUrl, store, db, nil)
+}
+
+// Download a ModuleFile from a proxy, and call check with its contents
+// before it is added to the Storage
+//
+// Files that are already stored are checked as well. Errors returned by
+// check are returned unchanged, and the file is not stored.
+func (f ModuleFile) download(proxyUrl *url.URL, store Storage, db *sumdb.Client, check func(contents io.ReaderAt, size int64) error) error {
 	fileUrl, err := proxyUrl.Parse(f.ProxyPath)
 	if err != nil {
 		return fmt.Errorf("Failed to build proxy Url: %v %v", proxyUrl, f.ProxyPath)
 	}
 
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		return fmt.Errorf("Failed to create output directory for %v: %v", filePath, err)
+	if info, err := store.Stat(f.FilePath); err == nil {
+		if check == nil {
+			return nil
+		}
+
+		stored, err := store.Open(f.FilePath)
+		if err != nil {
+			return err
+		}
+		defer stored.Close()
+
+		return check(stored, info.Size())
 	}
 
-	out, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
-	if os.IsExist(err) {
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("Failed to create destination file %v for %v: %v", filePath, fileUrl, err)
+	tmp, err := os.CreateTemp("", "goff-download-*")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %v: %v", fileUrl, err)
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
 	body, err := HttpGet(fileUrl)
 	if err != nil {
-		out.Close()
-		os.Remove(filePath)
 		return err
 	}
 	defer body.Close()
 
-	if _, err := io.Copy(out, body); err != nil {
-		out.Close()
-		os.Remove(filePath)
+	// Zip files larger than the go command's limit are rejected by checkZip
+	size, err := io.Copy(tmp, io.LimitReader(body, modzip.MaxZipFile+1))
+	if err != nil {
 		return fmt.Errorf("Could not download %v: %v", fileUrl, err)
 	}
 
-	if err := out.Close(); err != nil {
-		os.Remove(filePath)
-		return fmt.Errorf("Error closing %v: %v", fileUrl, err)
+	// Check the zip's structure before it is decompressed to be hashed
+	if f.Type == ModFileTypeZip {
+		if err := checkZip(f.Mod, tmp.Name()); err != nil {
+			return err
+		}
 	}
 
-	if err := f.checkSumDb(outdir, db); err != nil {
-		os.Remove(filePath)
+	if err := f.checkSumDb(tmp, size, db); err != nil {
 		return fmt.Errorf("Error validating file %v: %v", fileUrl, err)
 	}
 
+	// .info files have no checksum, so their contents are checked instead
+	if f.Type == ModFileTypeInfo {
+		if err := checkInfoContents(f.Mod, tmp, size); err != nil {
+			return err
+		}
+	}
+
+	if check != nil {
+		if err := check(tmp, size); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	out, err := store.Create(f.FilePath)
+	if err != nil {
+		return fmt.Errorf("Failed to create destination file %v for %v: %v", f.FilePath, fileUrl, err)
+	}
+
+	if _, err := io.Copy(out, tmp); err != nil {
+		out.Abort()
+		return fmt.Errorf("Failed to write %v: %v", f.FilePath, err)
+	}
+
+	if err := out.Commit(); err != nil {
+		return fmt.Errorf("Error saving %v: %v", f.FilePath, err)
+	}
+
 	return nil
 }
 
-func (f ModuleFile) getFileHash(outdir string) (string, string, error) {
-	filePath := path.Join(outdir, f.FilePath)
+// Validate a stored ModuleFile against the checksum database
+//
+// .info files are not in the checksum database, and their contents are
+// checked instead.
+func (f ModuleFile) Verify(store Storage, db *sumdb.Client) error {
+	file, err := store.Open(f.FilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
+	if err := f.checkSumDb(file, info.Size(), db); err != nil {
+		return fmt.Errorf("Error validating file %v: %v", f.FilePath, err)
+	}
+
+	if f.Type == ModFileTypeInfo {
+		return checkInfoContents(f.Mod, file, info.Size())
+	}
+
+	return nil
+}
+
+func (f ModuleFile) getFileHash(contents io.ReaderAt, size int64) (string, string, error) {
 	if f.Type == ModFileTypeModule {
 		hashVersion := f.Mod.Version + "/go.mod"
 		hash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
-			return os.Open(filePath)
+			return io.NopCloser(io.NewSectionReader(contents, 0, size)), nil
 		})
 		return hashVersion, hash, err
 	} else if f.Type == ModFileTypeZip {
 		hashVersion := f.Mod.Version
-		hash, err := dirhash.HashZip(filePath, dirhash.DefaultHash)
+		hash, err := hashZip(contents, size)
 		return hashVersion, hash, err
 	}
 
 	return "", "", nil
 }
 
-func (f ModuleFile) checkSumDb(outdir string, db *sumdb.Client) error {
-	hashVersion, hash, err := f.getFileHash(outdir)
+// Hash the contents of a zip file like dirhash.HashZip
+func hashZip(contents io.ReaderAt, size int64) (string, error) {
+	z, err := zip.NewReader(contents, size)
 	if err != nil {
-		return fmt.Errorf("Failed to hash mod file bytes: %v", err)
+		return "", err
+	}
+
+	var files []string
+	zfiles := make(map[string]*zip.File)
+	for _, file := range z.File {
+		files = append(files, file.Name)
+		zfiles[file.Name] = file
 	}
 
+	return dirhash.Hash1(files, func(name string) (io.ReadCloser, error) {
+		file := zfiles[name]
+		if file == nil {
+			return nil, fmt.Errorf("file %q not found in zip", name)
+		}
+		return file.Open()
+	})
+}
+
+func (f ModuleFile) checkSumDb(contents io.ReaderAt, size int64, db *sumdb.Client) error {
+	hashVersion, hash, err := f.getFileHash(contents, size)
+	if err != nil {
+		return fmt.Errorf("Failed to hash mod file bytes: %v", err)
+	}
 	// some files do not have checksums
 	if hash == "" {
 		return nil
@@ -0,0 +1,114 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage in a directory of the local filesystem
+type FileStorage struct {
+	Dir string
+}
+
+// Create a Storage for module files in a directory
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{Dir: dir}
+}
+
+// Get the filesystem path of a stored file
+func (s *FileStorage) FilePath(name string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(cleanName(name)))
+}
+
+func (s *FileStorage) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(s.FilePath(name))
+}
+
+func (s *FileStorage) Open(name string) (StorageFile, error) {
+	return os.Open(s.FilePath(name))
+}
+
+// Files are written to a hidden temporary file in the destination
+// directory, then renamed into place on Commit
+func (s *FileStorage) Create(name string) (StorageWriter, error) {
+	filePath := s.FilePath(name)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileWriter{File: tmp, path: filePath}, nil
+}
+
+// Empty parent directories are removed with the file
+func (s *FileStorage) Remove(name string) error {
+	filePath := s.FilePath(name)
+	if err := os.Remove(filePath); err != nil {
+		return err
+	}
+
+	for dir := filepath.Dir(filePath); dir != filepath.Clean(s.Dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Hidden files, including uncommitted files, are not listed
+func (s *FileStorage) List(dir string) ([]os.FileInfo, error) {
+	dirEntries, err := os.ReadDir(s.FilePath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]os.FileInfo, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, info)
+	}
+
+	return entries, nil
+}
+
+type fileWriter struct {
+	*os.File
+	path string
+}
+
+func (w *fileWriter) Commit() error {
+	if err := w.File.Chmod(0644); err != nil {
+		w.Abort()
+		return err
+	}
+
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+
+	if err := os.Rename(w.File.Name(), w.path); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (w *fileWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
@@ -0,0 +1,105 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/mod/semver"
+	"sort"
+)
+
+// An in-memory index of the module versions in a Storage
+//
+// Indexes are built once and never updated, which suits read-only storage
+// such as bundles.
+type Index struct {
+	modules map[string]*IndexedModule
+}
+
+// The stored versions of a module and the status of its latest version
+type IndexedModule struct {
+	Path string
+
+	// Stored versions in semver order
+	Versions []string
+
+	// Retractions and deprecation notice, nil if unknown
+	Status *ModuleStatus
+
+	info map[string]indexedInfo
+}
+
+type indexedInfo struct {
+	info *VersionInfo
+	data []byte
+}
+
+// Read the .info files and latest go.mod files of every module in a Storage
+func BuildIndex(s Storage) (*Index, error) {
+	stored, err := StoredModules(s)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Index{modules: make(map[string]*IndexedModule)}
+	for _, m := range stored {
+		infoBytes, err := ReadFile(s, m.InfoFile().FilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		versionInfo := new(VersionInfo)
+		if err := json.Unmarshal(infoBytes, versionInfo); err != nil {
+			return nil, fmt.Errorf("Invalid info file %v: %v", m.InfoFile().FilePath, err)
+		}
+
+		indexed, ok := index.modules[m.Path]
+		if !ok {
+			indexed = &IndexedModule{Path: m.Path, info: make(map[string]indexedInfo)}
+			index.modules[m.Path] = indexed
+		}
+
+		indexed.Versions = append(indexed.Versions, m.Version)
+		indexed.info[m.Version] = indexedInfo{info: versionInfo, data: infoBytes}
+	}
+
+	for _, indexed := range index.modules {
+		sort.Slice(indexed.Versions, func(a, b int) bool {
+			return semver.Compare(indexed.Versions[a], indexed.Versions[b]) < 0
+		})
+
+		latest := Module{Path: indexed.Path, Version: SelectLatest(indexed.Versions, nil)}
+		if latest.Version == "" {
+			continue
+		}
+
+		modFileBytes, err := ReadFile(s, latest.ModuleFile().FilePath)
+		if err != nil {
+			continue
+		}
+
+		indexed.Status, _ = ParseModuleStatus(latest.Path, latest.Version, modFileBytes)
+	}
+
+	return index, nil
+}
+
+// Get an indexed module by path, or nil if it is not stored
+func (i *Index) Module(modPath string) *IndexedModule {
+	return i.modules[modPath]
+}
+
+// Get the number of indexed modules and module versions
+func (i *Index) Count() (int, int) {
+	versions := 0
+	for _, indexed := range i.modules {
+		versions += len(indexed.Versions)
+	}
+
+	return len(i.modules), versions
+}
+
+// Get the decoded and raw contents of a version's .info file
+func (m *IndexedModule) Info(version string) (*VersionInfo, []byte, bool) {
+	indexed, ok := m.info[version]
+	return indexed.info, indexed.data, ok
+}
@@ -0,0 +1,107 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"io"
+	"time"
+)
+
+// How far in the future an .info Time can be, to allow for clock skew
+const maxInfoClockSkew = 24 * time.Hour
+
+// An .info file that does not describe the module version it was served
+// for
+//
+// .info files are not covered by the checksum database, so a proxy or module
+// set that rewrites them can only be detected by checking their contents.
+type InfoError struct {
+	Module Module
+	Reason string
+}
+
+func (e *InfoError) Error() string {
+	return fmt.Sprintf("Invalid info file for %v: %v", e.Module, e.Reason)
+}
+
+// Decode the .info file of a module version and check its contents
+//
+// The Version must be the canonical version that was requested, and the
+// Time must be set and not in the future. The Time of a pseudo-version
+// cannot be before the time encoded in the version. Proxies sometimes report
+// a later Time, so it is not required to match.
+func CheckInfo(m Module, infoBytes []byte) (*VersionInfo, error) {
+	info := new(VersionInfo)
+	if err := json.Unmarshal(infoBytes, info); err != nil {
+		return nil, &InfoError{m, err.Error()}
+	}
+
+	if info.Version != m.Version {
+		return nil, &InfoError{m, fmt.Sprintf("version %q does not match the requested version", info.Version)}
+	}
+
+	if !semver.IsValid(info.Version) || module.CanonicalVersion(info.Version) != info.Version {
+		return nil, &InfoError{m, fmt.Sprintf("version %q is not canonical", info.Version)}
+	}
+
+	if info.Time.IsZero() || info.Time.Before(time.Unix(0, 0)) {
+		return nil, &InfoError{m, fmt.Sprintf("invalid time %v", info.Time)}
+	}
+
+	if info.Time.After(time.Now().Add(maxInfoClockSkew)) {
+		return nil, &InfoError{m, fmt.Sprintf("time %v is in the future", info.Time.Format(time.RFC3339))}
+	}
+
+	if IsPseudoVersion(info.Version) {
+		pseudoTime, err := module.PseudoVersionTime(info.Version)
+		if err != nil {
+			return nil, &InfoError{m, err.Error()}
+		}
+
+		if info.Time.UTC().Truncate(time.Second).Before(pseudoTime) {
+			return nil, &InfoError{m, fmt.Sprintf("time %v is before the pseudo-version time %v",
+				info.Time.Format(time.RFC3339), pseudoTime.Format(time.RFC3339))}
+		}
+	}
+
+	return info, nil
+}
+
+// Check that a version is in a proxy's @v/list
+//
+// Pseudo-versions are not listed by proxies, and are not checked.
+func CheckListed(m Module, versions []string) error {
+	if IsPseudoVersion(m.Version) {
+		return nil
+	}
+
+	for _, version := range versions {
+		if version == m.Version {
+			return nil
+		}
+	}
+
+	return &InfoError{m, "version is not listed by @v/list"}
+}
+
+// Check the stored .info file of a module version
+func (m Module) CheckInfo(store Storage) (*VersionInfo, error) {
+	infoBytes, err := ReadFile(store, m.InfoFile().FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return CheckInfo(m, infoBytes)
+}
+
+func checkInfoContents(m Module, contents io.ReaderAt, size int64) error {
+	infoBytes, err := io.ReadAll(io.NewSectionReader(contents, 0, size))
+	if err != nil {
+		return err
+	}
+
+	_, err = CheckInfo(m, infoBytes)
+	return err
+}
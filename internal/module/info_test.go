@@ -0,0 +1,77 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckInfo(t *testing.T) {
+	future := time.Now().Add(48 * time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		version string
+		info    string
+		err     string
+	}{
+		{"v1.2.3", `{"Version":"v1.2.3","Time":"2023-01-01T00:00:00Z"}`, ""},
+		{"v1.2.3+incompatible", `{"Version":"v1.2.3+incompatible","Time":"2023-01-01T00:00:00Z"}`, ""},
+		{"v1.2.3", `{"Version":"v1.2.4","Time":"2023-01-01T00:00:00Z"}`, "does not match the requested version"},
+		{"v1.2", `{"Version":"v1.2","Time":"2023-01-01T00:00:00Z"}`, "is not canonical"},
+		{"v1.2.3+build", `{"Version":"v1.2.3+build","Time":"2023-01-01T00:00:00Z"}`, "is not canonical"},
+		{"master", `{"Version":"master","Time":"2023-01-01T00:00:00Z"}`, "is not canonical"},
+		{"v1.2.3", `{"Version":"v1.2.3"}`, "invalid time"},
+		{"v1.2.3", `{"Version":"v1.2.3","Time":"1969-12-31T00:00:00Z"}`, "invalid time"},
+		{"v1.2.3", fmt.Sprintf(`{"Version":"v1.2.3","Time":%q}`, future), "is in the future"},
+		{"v1.2.3", `{"Version":"v1.2.3","Time":`, "Invalid info file"},
+		{"v0.0.0-20230101120000-abcdefabcdef", `{"Version":"v0.0.0-20230101120000-abcdefabcdef","Time":"2023-01-01T12:00:00Z"}`, ""},
+		{"v0.0.0-20230101120000-abcdefabcdef", `{"Version":"v0.0.0-20230101120000-abcdefabcdef","Time":"2023-01-01T12:00:00.5Z"}`, ""},
+		{"v0.0.0-20230101120000-abcdefabcdef", `{"Version":"v0.0.0-20230101120000-abcdefabcdef","Time":"2023-02-01T00:00:00Z"}`, ""},
+		{"v0.0.0-20230101120000-abcdefabcdef", `{"Version":"v0.0.0-20230101120000-abcdefabcdef","Time":"2023-01-01T11:59:59Z"}`, "before the pseudo-version time"},
+		{"v1.2.4-0.20230101120000-abcdefabcdef", `{"Version":"v1.2.4-0.20230101120000-abcdefabcdef","Time":"2022-12-31T00:00:00Z"}`, "before the pseudo-version time"},
+	}
+
+	for _, test := range tests {
+		m := Module{Path: "example.com/a", Version: test.version}
+		info, err := CheckInfo(m, []byte(test.info))
+		if test.err == "" && (err != nil || info.Version != test.version) {
+			t.Errorf("CheckInfo(%v, %v) = %v, %v, expected version %v", m, test.info, info, err, test.version)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("CheckInfo(%v, %v) = %v, expected an error containing %q", m, test.info, err, test.err)
+		}
+
+		if _, isInfoError := err.(*InfoError); err != nil && !isInfoError {
+			t.Errorf("CheckInfo(%v, %v) returned %T, expected *InfoError", m, test.info, err)
+		}
+	}
+}
+
+func TestCheckListed(t *testing.T) {
+	versions := []string{"v1.0.0", "v1.1.0", "v1.2.0-rc.1"}
+	tests := []struct {
+		version string
+		listed  bool
+	}{
+		{"v1.0.0", true},
+		{"v1.2.0-rc.1", true},
+		{"v1.3.0", false},
+		{"v1.0.1-0.20230101120000-abcdefabcdef", true},
+		{"v0.0.0-20230101120000-abcdefabcdef", true},
+	}
+
+	for _, test := range tests {
+		m := Module{Path: "example.com/a", Version: test.version}
+		err := CheckListed(m, versions)
+		if (err == nil) != test.listed {
+			t.Errorf("CheckListed(%v) = %v, expected listed to be %v", m, err, test.listed)
+		}
+
+		if _, isInfoError := err.(*InfoError); err != nil && !isInfoError {
+			t.Errorf("CheckListed(%v) returned %T, expected *InfoError", m, err)
+		}
+	}
+
+	if err := CheckListed(Module{Path: "example.com/a", Version: "v1.0.0"}, nil); err == nil {
+		t.Errorf("CheckListed with an empty list succeeded")
+	}
+}
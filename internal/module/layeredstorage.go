@@ -0,0 +1,119 @@
+package module
+
+import (
+	"os"
+	"sort"
+)
+
+// Storage that combines several layers of storage in priority order
+//
+// Files are read from the first layer that contains them and directory
+// listings include the files of every layer. New files are created in the
+// first layer.
+type LayeredStorage struct {
+	Layers []Storage
+}
+
+// Create a Storage from layers in priority order, highest priority first
+func NewLayeredStorage(layers ...Storage) *LayeredStorage {
+	return &LayeredStorage{Layers: layers}
+}
+
+// Find the highest priority layer that contains a file
+//
+// Returns the layer's position in Layers along with the layer.
+func (s *LayeredStorage) Locate(name string) (int, Storage, error) {
+	var firstErr error
+	for i, layer := range s.Layers {
+		info, err := layer.Stat(name)
+		if err == nil && !info.IsDir() {
+			return i, layer, nil
+		}
+
+		if err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = notExist("stat", name)
+	}
+
+	return -1, nil, firstErr
+}
+
+func (s *LayeredStorage) Stat(name string) (os.FileInfo, error) {
+	var firstErr error
+	for _, layer := range s.Layers {
+		info, err := layer.Stat(name)
+		if err == nil {
+			return info, nil
+		}
+
+		if firstErr == nil || os.IsNotExist(firstErr) {
+			firstErr = err
+		}
+	}
+
+	if firstErr == nil {
+		firstErr = notExist("stat", name)
+	}
+
+	return nil, firstErr
+}
+
+func (s *LayeredStorage) Open(name string) (StorageFile, error) {
+	_, layer, err := s.Locate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.Open(name)
+}
+
+func (s *LayeredStorage) Create(name string) (StorageWriter, error) {
+	if len(s.Layers) == 0 {
+		return nil, &os.PathError{Op: "create", Path: name, Err: ErrReadOnly}
+	}
+
+	return s.Layers[0].Create(name)
+}
+
+func (s *LayeredStorage) List(dir string) ([]os.FileInfo, error) {
+	var entries []os.FileInfo
+	var firstErr error
+	found := false
+	seen := make(map[string]bool)
+
+	for _, layer := range s.Layers {
+		layerEntries, err := layer.List(dir)
+		if err != nil {
+			if firstErr == nil || os.IsNotExist(firstErr) {
+				firstErr = err
+			}
+			continue
+		}
+
+		found = true
+		for _, entry := range layerEntries {
+			if seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	if !found {
+		if firstErr == nil {
+			firstErr = notExist("list", dir)
+		}
+		return nil, firstErr
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Name() < entries[b].Name()
+	})
+
+	return entries, nil
+}
@@ -0,0 +1,210 @@
+package module
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/haboustak/goff/internal/license"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The directory of a Storage that holds manifests
+//
+// Module paths must have a dot in their first element, so the directory
+// cannot be mistaken for a module.
+const ManifestDir = "manifests"
+
+// A record of the modules collected or uploaded by one operation
+type Manifest struct {
+	ID       string
+	Time     time.Time
+	Action   string
+	Operator string
+	Source   string
+	Target   string
+
+	// Why the modules were rejected
+	Reason string `json:",omitempty"`
+
+	Modules []ManifestModule
+
+	mu sync.Mutex
+}
+
+// A module version listed in a manifest
+type ManifestModule struct {
+	Path    string
+	Version string
+
+	// Checksum database hashes of the mod and zip files, keyed by file type
+	Hashes map[string]string `json:",omitempty"`
+
+	// Licenses found in the license files of the zip file
+	Licenses []license.Match `json:",omitempty"`
+}
+
+// Create an empty manifest with a new, unique ID
+func NewManifest(action string, operator string, source string, target string) *Manifest {
+	now := time.Now().UTC()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return &Manifest{
+		ID:       now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix),
+		Time:     now,
+		Action:   action,
+		Operator: operator,
+		Source:   source,
+		Target:   target,
+	}
+}
+
+// Add a module, its hashes and its licenses to the manifest
+func (m *Manifest) Add(mod Module, hashes map[string]string, licenses []license.Match) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Modules = append(m.Modules, ManifestModule{
+		Path:     mod.Path,
+		Version:  mod.Version,
+		Hashes:   hashes,
+		Licenses: licenses,
+	})
+}
+
+// Find a module in the manifest, or nil if it is not listed
+func (m *Manifest) Module(mod Module) *ManifestModule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.Modules {
+		if m.Modules[i].Path == mod.Path && m.Modules[i].Version == mod.Version {
+			return &m.Modules[i]
+		}
+	}
+
+	return nil
+}
+
+// Get the name of the manifest file in a Storage
+func (m *Manifest) FilePath() string {
+	return path.Join(ManifestDir, m.ID+".json")
+}
+
+// Write the manifest to a Storage, with modules in path and version order
+func (m *Manifest) Save(store Storage) error {
+	m.mu.Lock()
+	sort.Slice(m.Modules, func(a, b int) bool {
+		if m.Modules[a].Path != m.Modules[b].Path {
+			return m.Modules[a].Path < m.Modules[b].Path
+		}
+		return m.Modules[a].Version < m.Modules[b].Version
+	})
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(store, m.FilePath(), append(data, '\n'))
+}
+
+// Read a manifest from a Storage by ID
+func LoadManifest(store Storage, id string) (*Manifest, error) {
+	if id == "" || strings.ContainsAny(id, "/\\") {
+		return nil, fmt.Errorf("Invalid manifest ID %q", id)
+	}
+
+	data, err := ReadFile(store, path.Join(ManifestDir, id+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(Manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("Invalid manifest %v: %v", id, err)
+	}
+
+	return m, nil
+}
+
+// Get the IDs of the manifests in a Storage, oldest first
+func StoredManifests(store Storage) ([]string, error) {
+	entries, err := store.List(ManifestDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// Get the checksum database hashes of a module's stored mod and zip files
+//
+// The hashes are keyed by file type, "mod" or "zip". Files that are not
+// stored are left out.
+func (m Module) Hashes(store Storage) (map[string]string, error) {
+	hashes := make(map[string]string)
+	for _, file := range []ModuleFile{m.ModuleFile(), m.ZipFile()} {
+		contents, err := store.Open(file.FilePath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		info, err := contents.Stat()
+		if err == nil {
+			_, hashes[strings.TrimPrefix(string(file.Type), ".")], err = file.getFileHash(contents, info.Size())
+		}
+		contents.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to hash %v: %v", file.FilePath, err)
+		}
+	}
+
+	return hashes, nil
+}
+
+// Identify the licenses of a module from the license files of its stored zip
+// file
+//
+// Returns nil when the zip file is not stored.
+func (m Module) Licenses(store Storage) ([]license.Match, error) {
+	contents, err := store.Open(m.ZipFile().FilePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+
+	info, err := contents.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := license.ScanZip(contents, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to scan licenses of %v: %v", m, err)
+	}
+
+	return matches, nil
+}
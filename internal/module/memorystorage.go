@@ -0,0 +1,132 @@
+package module
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage that keeps module files in memory
+type MemoryStorage struct {
+	files map[string]memoryFile
+	sync.RWMutex
+}
+
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// Create an empty in-memory Storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files: make(map[string]memoryFile),
+	}
+}
+
+func (s *MemoryStorage) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.files[name]; ok {
+		return s.stat(name), nil
+	}
+
+	if entries, err := listNames(s.names(), name, s.stat); err == nil && len(entries) > 0 {
+		return storageFileInfo{name: path.Base(name), dir: true}, nil
+	}
+
+	return nil, notExist("stat", name)
+}
+
+func (s *MemoryStorage) Open(name string) (StorageFile, error) {
+	name = cleanName(name)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	file, ok := s.files[name]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+
+	reader := bytes.NewReader(file.data)
+	return sectionFile{
+		SectionReader: io.NewSectionReader(reader, 0, int64(len(file.data))),
+		info:          s.stat(name),
+	}, nil
+}
+
+func (s *MemoryStorage) Create(name string) (StorageWriter, error) {
+	return &memoryWriter{storage: s, name: cleanName(name)}, nil
+}
+
+func (s *MemoryStorage) Remove(name string) error {
+	name = cleanName(name)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.files[name]; !ok {
+		return notExist("remove", name)
+	}
+	delete(s.files, name)
+
+	return nil
+}
+
+func (s *MemoryStorage) List(dir string) ([]os.FileInfo, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return listNames(s.names(), dir, s.stat)
+}
+
+// Get the sorted names of all stored files, the caller must hold the lock
+func (s *MemoryStorage) names() []string {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Get information about a stored file, the caller must hold the lock
+func (s *MemoryStorage) stat(name string) storageFileInfo {
+	file := s.files[name]
+	return storageFileInfo{
+		name:    path.Base(name),
+		size:    int64(len(file.data)),
+		modTime: file.modTime,
+	}
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	storage *MemoryStorage
+	name    string
+}
+
+func (w *memoryWriter) Commit() error {
+	w.storage.Lock()
+	defer w.storage.Unlock()
+
+	w.storage.files[w.name] = memoryFile{
+		data:    w.Buffer.Bytes(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (w *memoryWriter) Abort() error {
+	w.Buffer.Reset()
+	return nil
+}
@@ -0,0 +1,98 @@
+package module
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// A file that lists each requested module that was not available
+//
+// Modules are written one per line as path@version, or just the path when no
+// version was requested. Every module is only listed once, including modules
+// that were listed before the file was opened.
+type MissingLog struct {
+	file *os.File
+	seen map[string]bool
+	sync.Mutex
+}
+
+// Open or create a missing module log for appending
+func OpenMissingLog(logPath string) (*MissingLog, error) {
+	names, err := ReadModuleList(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &MissingLog{
+		file: file,
+		seen: make(map[string]bool),
+	}
+	for _, name := range names {
+		l.seen[name] = true
+	}
+
+	return l, nil
+}
+
+// Add a module to the log, unless it is already listed
+func (l *MissingLog) Record(m Module) error {
+	name := m.Path
+	if m.Version != "" {
+		name = m.String()
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.seen[name] {
+		return nil
+	}
+
+	if _, err := fmt.Fprintln(l.file, name); err != nil {
+		return err
+	}
+	l.seen[name] = true
+
+	return nil
+}
+
+// Close the log
+func (l *MissingLog) Close() error {
+	return l.file.Close()
+}
+
+// Read a list of module names from a file
+//
+// Names are read one per line. Blank lines and lines starting with # are
+// ignored.
+func ReadModuleList(listPath string) ([]string, error) {
+	file, err := os.Open(listPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read module list %v: %v", listPath, err)
+	}
+
+	return names, nil
+}
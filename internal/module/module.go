@@ -2,8 +2,8 @@ package module
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
+	"github.com/haboustak/goff/internal/license"
 	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
@@ -13,11 +13,13 @@ import (
 	"path"
 	"sort"
 	"strings"
+	"time"
 )
 
-type moduleInfo struct {
+// The contents of a proxy's .info file
+type VersionInfo struct {
 	Version string
-	Time    string
+	Time    time.Time
 }
 
 type Module struct {
@@ -46,6 +48,80 @@ func Parse(fullName string) (Module, error) {
 		m.Version = fullName[idx+1:]
 	}
 
+	if err := module.CheckPath(m.Path); err != nil {
+		return m, err
+	}
+
+	// Version queries are checked once they are resolved, but an explicit
+	// +incompatible version can be checked now
+	if semver.Build(m.Version) == "+incompatible" {
+		if err := m.Check(); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+// Check that the module path and canonical version correspond
+//
+// Modules at major version 2 or higher must either have a matching /vN (or
+// gopkg.in .vN) path suffix, or use a +incompatible version because they do
+// not have a go.mod file.
+func (m Module) Check() error {
+	if err := module.Check(m.Path, m.Version); err != nil {
+		return fmt.Errorf("%v%v", err, m.majorVersionHint())
+	}
+
+	if semver.Build(m.Version) == "+incompatible" {
+		_, pathMajor, _ := module.SplitPathVersion(m.Path)
+		if pathMajor != "" {
+			return fmt.Errorf("%v: +incompatible versions cannot be used with major version suffix %v", m, pathMajor)
+		}
+
+		if major := semver.Major(m.Version); major == "v0" || major == "v1" {
+			return fmt.Errorf("%v: +incompatible is only used for major version v2 or higher, not %v", m, major)
+		}
+	}
+
+	return nil
+}
+
+// Suggest the path or version that a mismatched major version probably meant
+func (m Module) majorVersionHint() string {
+	major := semver.Major(m.Version)
+	prefix, pathMajor, ok := module.SplitPathVersion(m.Path)
+	if !ok || major == "" || major == "v0" || major == "v1" {
+		return ""
+	}
+
+	if strings.HasPrefix(m.Path, "gopkg.in/") {
+		return fmt.Sprintf("\nDid you mean %v.%v@%v?", prefix, major, m.Version)
+	}
+
+	if pathMajor != "" {
+		return fmt.Sprintf("\nDid you mean %v/%v@%v?", prefix, major, m.Version)
+	}
+
+	return fmt.Sprintf("\nDid you mean %v/%v@%v, or %v@%v+incompatible if the module has no go.mod file?",
+		prefix, major, m.Version, m.Path, semver.Canonical(m.Version))
+}
+
+// Read a module from the escaped path and version used by proxy urls
+func ParseEscaped(escapedPath string, escapedVersion string) (Module, error) {
+	var m Module
+	var err error
+
+	if m.Path, err = module.UnescapePath(escapedPath); err != nil {
+		return m, err
+	}
+
+	if escapedVersion != "" {
+		if m.Version, err = module.UnescapeVersion(escapedVersion); err != nil {
+			return m, err
+		}
+	}
+
 	return m, nil
 }
 
@@ -86,43 +162,68 @@ func (m Module) LatestVersion(proxyUrl *url.URL) (string, error) {
 	latestInfoPath := path.Join(m.EscapedPath(), "@latest")
 	fileUrl, _ := proxyUrl.Parse(latestInfoPath)
 
-	versionResp, err := HttpGet(fileUrl)
+	versionInfo, err := fetchInfo(fileUrl)
 	if err != nil {
 		return m.Version, err
 	}
-	defer versionResp.Close()
 
-	infoBytes, err := io.ReadAll(versionResp)
-	if err != nil {
-		return m.Version, err
-	}
+	return versionInfo.Version, nil
+}
 
-	versionInfo := new(moduleInfo)
-	if err := json.Unmarshal(infoBytes, versionInfo); err != nil {
-		return m.Version, err
+// Download and validate the zip, mod, and info files from a module proxy
+//
+// Nothing is downloaded when the module version is denied by the policy,
+// and the zip file is not stored when its licenses are denied. The .info
+// file is downloaded last, because proxies list a version as soon as its
+// .info file exists.
+func (m Module) Download(proxyUrl *url.URL, store Storage, db *sumdb.Client, policy *Policy) error {
+	if err := policy.Check(m); err != nil {
+		return err
 	}
 
-	return versionInfo.Version, nil
-}
+	var checkLicenses func(io.ReaderAt, int64) error
+	if policy.HasLicenseRules() {
+		checkLicenses = func(contents io.ReaderAt, size int64) error {
+			matches, err := license.ScanZip(contents, size)
+			if err != nil {
+				return fmt.Errorf("Failed to scan licenses of %v: %v", m, err)
+			}
+			return policy.CheckLicenses(m, license.IDs(matches))
+		}
+	}
 
-// Download and validate the info, mod, and zip files from a module proxy
-func (m Module) Download(proxyUrl *url.URL, outdir string, db *sumdb.Client) error {
-	infoFile := m.InfoFile()
-	err := infoFile.Download(proxyUrl, outdir, db)
-	if err != nil {
-		return fmt.Errorf("Failed to download module info: %v", err)
+	zipFile := m.ZipFile()
+	err := zipFile.download(proxyUrl, store, db, checkLicenses)
+	if _, denied := err.(*PolicyViolation); denied {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("Failed to download module zip: %v", err)
 	}
 
 	modFile := m.ModuleFile()
-	err = modFile.Download(proxyUrl, outdir, db)
+	err = modFile.Download(proxyUrl, store, db)
 	if err != nil {
 		return fmt.Errorf("Failed to download module mod: %v", err)
 	}
 
-	zipFile := m.ZipFile()
-	err = zipFile.Download(proxyUrl, outdir, db)
-	if err != nil {
-		return fmt.Errorf("Failed to download module zip: %v", err)
+	infoFile := m.InfoFile()
+	err = infoFile.Download(proxyUrl, store, db)
+	if _, invalid := err.(*InfoError); invalid {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("Failed to download module info: %v", err)
+	}
+
+	return nil
+}
+
+// Check that all module files are stored and validate the mod and zip
+// files against the checksum database
+func (m Module) Verify(store Storage, db *sumdb.Client) error {
+	for _, file := range []ModuleFile{m.InfoFile(), m.ModuleFile(), m.ZipFile()} {
+		if err := file.Verify(store, db); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -158,7 +259,15 @@ func (m Module) Versions(proxyUrl *url.URL) ([]string, error) {
 
 // Download the module file for a user-provided path and version
 // and convert the path to the canonical form
+//
+// Only the capitalization of a path is rewritten. A go.mod file that declares
+// a different path, such as one with a different major version suffix, is an
+// error.
 func (m Module) CanonicalizePath(proxyUrl *url.URL) (string, error) {
+	if err := m.Check(); err != nil {
+		return m.Path, err
+	}
+
 	modFile := m.ModuleFile()
 	modUrl, err := proxyUrl.Parse(modFile.ProxyPath)
 	if err != nil {
@@ -194,5 +303,25 @@ func (m Module) CanonicalizePath(proxyUrl *url.URL) (string, error) {
 		return m.Path, fmt.Errorf("Failed to parse gomod file for %s: %v", m.String(), err)
 	}
 
-	return modDetails.Module.Mod.Path, nil
+	// The proxy synthesizes go.mod files for +incompatible versions from the
+	// requested path, so they cannot be used to correct it
+	if semver.Build(m.Version) == "+incompatible" || modDetails.Module == nil {
+		return m.Path, nil
+	}
+
+	declaredPath := modDetails.Module.Mod.Path
+	if declaredPath == m.Path {
+		return m.Path, nil
+	}
+
+	if !strings.EqualFold(declaredPath, m.Path) {
+		return m.Path, fmt.Errorf("%v: go.mod declares its path as %v, but it was requested as %v", m, declaredPath, m.Path)
+	}
+
+	canonical := Module{Path: declaredPath, Version: m.Version}
+	if err := canonical.Check(); err != nil {
+		return m.Path, err
+	}
+
+	return declaredPath, nil
 }
@@ -0,0 +1,398 @@
+package module
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/haboustak/goff/internal/license"
+	"golang.org/x/mod/semver"
+	"os"
+	"path"
+	"strings"
+)
+
+// Rules that decide which module versions can enter a module set or proxy
+//
+// Each line of a policy file is a rule:
+//
+//	deny PATTERN [RANGE] [REASON...]
+//	allow PATTERN [RANGE] [REASON...]
+//	deny-license LICENSE [REASON...]
+//	allow-license LICENSE [REASON...]
+//
+// PATTERN is a glob matched against the module path and each of its parent
+// paths, so it also matches every module below a path prefix. RANGE is a
+// comma-separated list of version terms that must all match: a version
+// (v1.2.3), a version prefix (v1.2) or a comparison (<v1.3.0, >=v1.2.0).
+// Without a RANGE, a rule matches every version. A REASON that starts with a
+// version is read as a RANGE, so it must be quoted, like "v2 is unsupported".
+//
+// A module version is denied by the first deny rule that matches it. When
+// the policy has allow rules, a module version that matches none of them is
+// also denied.
+//
+// LICENSE is a glob matched against the SPDX IDs of the licenses found in a
+// module's zip file, including NONE and NOASSERTION, see the license
+// package. A module is denied by the first deny-license rule that matches
+// any of its licenses. When the policy has allow-license rules, each of its
+// licenses must match one of them. GNU licenses are found by their family
+// ID, like GPL-3.0, which a deny-license rule for either its -only or
+// -or-later ID matches, and which is only allowed when both are.
+type Policy struct {
+	File  string
+	Rules []PolicyRule
+}
+
+// A single allow or deny line of a policy file
+type PolicyRule struct {
+	Line    int
+	Allow   bool
+	License bool
+	Pattern string
+	Range   string
+	Reason  string
+}
+
+// An error for a module version that is denied by a policy
+type PolicyViolation struct {
+	Module Module
+	Reason string
+
+	// The deny rule that matched, or nil when no allow rule matched
+	Rule *PolicyRule
+}
+
+func (v *PolicyViolation) Error() string {
+	if v.Rule == nil {
+		return fmt.Sprintf("%v is denied by policy: %v", v.Module, v.Reason)
+	}
+
+	return fmt.Sprintf("%v is denied by policy rule %v: %v", v.Module, v.Rule.Line, v.Reason)
+}
+
+// Read a policy file
+func LoadPolicy(fileName string) (*Policy, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p := &Policy{File: fileName}
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := parsePolicyRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", fileName, lineNumber, err)
+		}
+
+		rule.Line = lineNumber
+		p.Rules = append(p.Rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func parsePolicyRule(line string) (PolicyRule, error) {
+	var rule PolicyRule
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return rule, fmt.Errorf("Expected allow or deny and a module path pattern")
+	}
+
+	switch fields[0] {
+	case "allow", "allow-license":
+		rule.Allow = true
+	case "deny", "deny-license":
+	default:
+		return rule, fmt.Errorf("Unknown policy action %q", fields[0])
+	}
+	rule.License = strings.HasSuffix(fields[0], "-license")
+
+	rule.Pattern = fields[1]
+	if _, err := path.Match(rule.Pattern, ""); err != nil {
+		return rule, fmt.Errorf("Invalid pattern %q", rule.Pattern)
+	}
+
+	fields = fields[2:]
+	if !rule.License && len(fields) > 0 && isVersionRange(fields[0]) {
+		rule.Range = fields[0]
+		fields = fields[1:]
+	}
+
+	rule.Reason = strings.Join(fields, " ")
+	if len(rule.Reason) >= 2 && strings.HasPrefix(rule.Reason, `"`) && strings.HasSuffix(rule.Reason, `"`) {
+		rule.Reason = rule.Reason[1 : len(rule.Reason)-1]
+	}
+
+	return rule, nil
+}
+
+// Check that a module version is allowed by the policy
+//
+// Returns a *PolicyViolation when it is denied. A nil policy allows every
+// module.
+func (p *Policy) Check(m Module) error {
+	if p == nil {
+		return nil
+	}
+
+	hasAllow := false
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.License {
+			continue
+		}
+
+		if rule.Allow {
+			hasAllow = true
+			continue
+		}
+
+		if rule.Matches(m) {
+			reason := rule.Reason
+			if reason == "" {
+				reason = "no reason given"
+			}
+			return &PolicyViolation{Module: m, Rule: rule, Reason: reason}
+		}
+	}
+
+	if !hasAllow {
+		return nil
+	}
+
+	for i := range p.Rules {
+		if p.Rules[i].Allow && !p.Rules[i].License && p.Rules[i].Matches(m) {
+			return nil
+		}
+	}
+
+	return &PolicyViolation{Module: m, Reason: "not matched by any allow rule"}
+}
+
+// Check that some version of a module path can be allowed by the policy
+//
+// Only deny rules without a RANGE deny a path, and with allow rules, a path
+// is denied when it matches none of their patterns. Returns a
+// *PolicyViolation for the path when it is denied.
+func (p *Policy) CheckPath(modPath string) error {
+	if p == nil {
+		return nil
+	}
+
+	m := Module{Path: modPath}
+	hasAllow, allowed := false, false
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.License {
+			continue
+		}
+
+		if rule.Allow {
+			hasAllow = true
+			allowed = allowed || rule.matchesPath(modPath)
+			continue
+		}
+
+		if rule.Range == "" && rule.matchesPath(modPath) {
+			reason := rule.Reason
+			if reason == "" {
+				reason = "no reason given"
+			}
+			return &PolicyViolation{Module: m, Rule: rule, Reason: reason}
+		}
+	}
+
+	if hasAllow && !allowed {
+		return &PolicyViolation{Module: m, Reason: "not matched by any allow rule"}
+	}
+
+	return nil
+}
+
+// Test if the policy has rules for licenses
+func (p *Policy) HasLicenseRules() bool {
+	if p == nil {
+		return false
+	}
+
+	for _, rule := range p.Rules {
+		if rule.License {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check that the licenses of a module version are allowed by the policy
+//
+// licenses are the SPDX IDs found in the module's zip file, see license.IDs.
+// Returns a *PolicyViolation when it is denied. A nil policy allows every
+// license.
+func (p *Policy) CheckLicenses(m Module, licenses []string) error {
+	if p == nil {
+		return nil
+	}
+
+	hasAllow := false
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if !rule.License {
+			continue
+		}
+
+		if rule.Allow {
+			hasAllow = true
+			continue
+		}
+
+		for _, id := range licenses {
+			if rule.matchesLicense(id) {
+				reason := "license " + id
+				if rule.Reason != "" {
+					reason += ", " + rule.Reason
+				}
+				return &PolicyViolation{Module: m, Rule: rule, Reason: reason}
+			}
+		}
+	}
+
+	if !hasAllow {
+		return nil
+	}
+
+	for _, id := range licenses {
+		if !p.allowsLicense(id) {
+			return &PolicyViolation{Module: m, Reason: "license " + id + " is not matched by any allow-license rule"}
+		}
+	}
+
+	return nil
+}
+
+// Test if an allow-license rule matches a license ID
+//
+// The family ID of a GNU license is allowed when the rules allow the family
+// or both of its -only and -or-later forms.
+func (p *Policy) allowsLicense(id string) bool {
+	allowed := func(id string) bool {
+		for _, rule := range p.Rules {
+			if ok, _ := path.Match(rule.Pattern, id); ok && rule.License && rule.Allow {
+				return true
+			}
+		}
+		return false
+	}
+
+	if allowed(id) {
+		return true
+	}
+
+	for _, form := range license.Forms(id) {
+		if form == id || !allowed(form) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Test if a license rule matches a license ID
+//
+// The family ID of a GNU license is matched by a rule that matches either of
+// its -only and -or-later forms, see license.Forms.
+func (r *PolicyRule) matchesLicense(id string) bool {
+	for _, form := range append([]string{id}, license.Forms(id)...) {
+		if ok, _ := path.Match(r.Pattern, form); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Test if a rule matches a module version
+func (r *PolicyRule) Matches(m Module) bool {
+	matched := r.matchesPath(m.Path)
+	if !matched || r.Range == "" {
+		return matched
+	}
+
+	if m.Version == "" {
+		return false
+	}
+
+	for _, term := range strings.Split(r.Range, ",") {
+		if !matchesVersionTerm(m.Version, term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Test if the pattern of a rule matches a module path or one of its parents
+func (r *PolicyRule) matchesPath(modPath string) bool {
+	for prefix := modPath; prefix != "." && prefix != "/" && prefix != ""; prefix = path.Dir(prefix) {
+		if ok, _ := path.Match(r.Pattern, prefix); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Test if every comma-separated term of s is a version, version prefix or
+// comparison
+func isVersionRange(s string) bool {
+	for _, term := range strings.Split(s, ",") {
+		bound := strings.TrimLeft(term, "<>=")
+		switch term[:len(term)-len(bound)] {
+		case "", "=", "<", "<=", ">", ">=":
+		default:
+			return false
+		}
+
+		if !semver.IsValid(bound) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesVersionTerm(version string, term string) bool {
+	bound := strings.TrimLeft(term, "<>=")
+	op := term[:len(term)-len(bound)]
+	cmp := semver.Compare(version, bound)
+
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	if isVersionPrefix(bound) && semver.Canonical(bound) != bound {
+		return matchesPrefix(version, bound)
+	}
+
+	return cmp == 0
+}
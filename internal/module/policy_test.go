@@ -0,0 +1,183 @@
+package module
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Load a policy file holding the given lines
+func testPolicy(t *testing.T, lines ...string) *Policy {
+	fileName := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(fileName, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadPolicy(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestParsePolicyRule(t *testing.T) {
+	tests := []struct {
+		line string
+		rule PolicyRule
+		err  bool
+	}{
+		{"deny example.com/a", PolicyRule{Pattern: "example.com/a"}, false},
+		{"allow example.com/*", PolicyRule{Allow: true, Pattern: "example.com/*"}, false},
+		{"deny example.com/a <v1.3.0 CVE-2022-1234", PolicyRule{Pattern: "example.com/a", Range: "<v1.3.0", Reason: "CVE-2022-1234"}, false},
+		{"deny example.com/a >=v1.2.0,<v1.2.5 broken release", PolicyRule{Pattern: "example.com/a", Range: ">=v1.2.0,<v1.2.5", Reason: "broken release"}, false},
+		{"deny example.com/a v1.2", PolicyRule{Pattern: "example.com/a", Range: "v1.2"}, false},
+		{"deny example.com/a v2 is unsupported", PolicyRule{Pattern: "example.com/a", Range: "v2", Reason: "is unsupported"}, false},
+		{`deny example.com/a "v2 is unsupported"`, PolicyRule{Pattern: "example.com/a", Reason: "v2 is unsupported"}, false},
+		{"deny example.com/a v1.2.3: yanked", PolicyRule{Pattern: "example.com/a", Reason: "v1.2.3: yanked"}, false},
+		{"deny example.com/a =>v1.2.0 typo", PolicyRule{Pattern: "example.com/a", Reason: "=>v1.2.0 typo"}, false},
+		{"deny-license AGPL-* network copyleft", PolicyRule{License: true, Pattern: "AGPL-*", Reason: "network copyleft"}, false},
+		{"allow-license v1.0.0", PolicyRule{Allow: true, License: true, Pattern: "v1.0.0"}, false},
+		{"deny", PolicyRule{}, true},
+		{"block example.com/a", PolicyRule{}, true},
+		{"deny example.com/[a", PolicyRule{}, true},
+	}
+
+	for _, test := range tests {
+		rule, err := parsePolicyRule(test.line)
+		if test.err {
+			if err == nil {
+				t.Errorf("parsePolicyRule(%q) succeeded, expected an error", test.line)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parsePolicyRule(%q) failed: %v", test.line, err)
+		} else if rule != test.rule {
+			t.Errorf("parsePolicyRule(%q) = %+v, expected %+v", test.line, rule, test.rule)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := testPolicy(t,
+		"# modules from unapproved hosts",
+		"deny github.com/badcorp/*     unapproved host",
+		"deny golang.org/x/text <v0.3.8 CVE-2022-32149",
+		"deny example.com/b >=v1.2.0,<v1.2.5",
+		"deny example.com/c v1.4",
+		"allow golang.org/x",
+		"allow github.com/*",
+		"allow example.com/*",
+	)
+
+	tests := []struct {
+		module string
+		line   int
+	}{
+		{"github.com/badcorp/tool@v1.0.0", 2},
+		{"github.com/badcorp/tool/v2@v2.0.0", 2},
+		{"github.com/badcorp@v1.0.0", 0},
+		{"github.com/goodcorp/tool@v1.0.0", 0},
+		{"golang.org/x/text@v0.3.7", 3},
+		{"golang.org/x/text@v0.3.8", 0},
+		{"golang.org/x/text@v0.0.0-20170915032832-14c0d48ead0c", 3},
+		{"golang.org/x/mod@v0.1.0", 0},
+		{"example.com/b@v1.1.9", 0},
+		{"example.com/b@v1.2.0", 4},
+		{"example.com/b@v1.2.4", 4},
+		{"example.com/b@v1.2.5", 0},
+		{"example.com/c@v1.4.2", 5},
+		{"example.com/c@v1.40.0", 0},
+		{"example.com/c@v1.5.0", 0},
+		{"gopkg.in/yaml.v3@v3.0.0", -1},
+		{"golang.org/xtra@v1.0.0", -1},
+	}
+
+	for _, test := range tests {
+		m, err := Parse(test.module)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Check(m)
+		violation, denied := err.(*PolicyViolation)
+		switch {
+		case test.line == 0 && err != nil:
+			t.Errorf("Check(%v) = %v, expected it to be allowed", m, err)
+		case test.line != 0 && !denied:
+			t.Errorf("Check(%v) = %v, expected a policy violation", m, err)
+		case test.line > 0 && (violation.Rule == nil || violation.Rule.Line != test.line):
+			t.Errorf("Check(%v) = %v, expected it to be denied by line %v", m, err, test.line)
+		case test.line < 0 && violation.Rule != nil:
+			t.Errorf("Check(%v) = %v, expected it to match no allow rule", m, err)
+		}
+	}
+
+	var nilPolicy *Policy
+	if err := nilPolicy.Check(Module{Path: "example.com/a", Version: "v1.0.0"}); err != nil {
+		t.Errorf("Check with a nil policy = %v, expected no error", err)
+	}
+}
+
+func TestPolicyCheckPath(t *testing.T) {
+	p := testPolicy(t,
+		"deny github.com/badcorp/*",
+		"deny golang.org/x/text <v0.3.8",
+		"allow golang.org/x",
+		"allow github.com/*",
+	)
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"github.com/badcorp/tool", false},
+		{"github.com/goodcorp/tool", true},
+		{"golang.org/x/text", true},
+		{"golang.org/x/text/v2", true},
+		{"gopkg.in/yaml.v3", false},
+	}
+
+	for _, test := range tests {
+		if err := p.CheckPath(test.path); (err == nil) != test.allowed {
+			t.Errorf("CheckPath(%v) = %v, expected allowed to be %v", test.path, err, test.allowed)
+		}
+	}
+}
+
+func TestPolicyCheckLicenses(t *testing.T) {
+	m := Module{Path: "example.com/a", Version: "v1.0.0"}
+	tests := []struct {
+		rules    []string
+		licenses []string
+		allowed  bool
+	}{
+		{[]string{"deny-license AGPL-*"}, []string{"MIT"}, true},
+		{[]string{"deny-license AGPL-*"}, []string{"MIT", "AGPL-3.0"}, false},
+		{[]string{"allow-license MIT", "allow-license BSD-*"}, []string{"MIT", "BSD-3-Clause"}, true},
+		{[]string{"allow-license MIT", "allow-license BSD-*"}, []string{"MIT", "Apache-2.0"}, false},
+		{[]string{"allow-license MIT"}, []string{"NOASSERTION"}, false},
+		{[]string{"allow-license MIT", "allow-license NONE"}, []string{"NONE"}, true},
+		{[]string{"deny-license MIT", "allow-license MIT"}, []string{"MIT"}, false},
+		{[]string{"deny-license GPL-3.0-only"}, []string{"GPL-3.0"}, false},
+		{[]string{"deny-license GPL-3.0-or-later"}, []string{"GPL-3.0"}, false},
+		{[]string{"deny-license GPL-3.0-or-later"}, []string{"LGPL-3.0"}, true},
+		{[]string{"allow-license GPL-3.0-or-later"}, []string{"GPL-3.0"}, false},
+		{[]string{"allow-license GPL-3.0-only", "allow-license GPL-3.0-or-later"}, []string{"GPL-3.0"}, true},
+		{[]string{"allow-license GPL-3.0"}, []string{"GPL-3.0"}, true},
+		{[]string{"allow-license *GPL-*"}, []string{"LGPL-2.1"}, true},
+		{[]string{"deny example.com/*"}, []string{"MIT"}, true},
+	}
+
+	for _, test := range tests {
+		p := testPolicy(t, test.rules...)
+		if err := p.CheckLicenses(m, test.licenses); (err == nil) != test.allowed {
+			t.Errorf("CheckLicenses(%v) with %v = %v, expected allowed to be %v", test.licenses, test.rules, err, test.allowed)
+		} else if _, isViolation := err.(*PolicyViolation); err != nil && !isViolation {
+			t.Errorf("CheckLicenses(%v) with %v returned %T, expected *PolicyViolation", test.licenses, test.rules, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package module
+
+import (
+	"golang.org/x/mod/module"
+	"strings"
+)
+
+// Shortest abbreviated commit hash accepted by MatchesRevision
+const minRevisionLength = 7
+
+// Test if a version is a pseudo-version such as v0.0.0-20191109021931-daa7c04131f5
+func IsPseudoVersion(v string) bool {
+	return module.IsPseudoVersion(v)
+}
+
+// Test if a pseudo-version refers to a commit hash
+//
+// The hash may be a full commit hash or an abbreviation of at least seven
+// hexadecimal characters.
+func MatchesRevision(v string, hash string) bool {
+	if len(hash) < minRevisionLength || strings.Trim(strings.ToLower(hash), "0123456789abcdef") != "" {
+		return false
+	}
+
+	rev, err := module.PseudoVersionRev(v)
+	if err != nil {
+		return false
+	}
+
+	hash = strings.ToLower(hash)
+	return strings.HasPrefix(hash, rev) || strings.HasPrefix(rev, hash)
+}
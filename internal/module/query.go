@@ -0,0 +1,277 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Version queries understood by the go command
+const (
+	QueryLatest  = "latest"
+	QueryUpgrade = "upgrade"
+	QueryPatch   = "patch"
+)
+
+// Resolve the module's version query to a canonical version or pseudo-version
+//
+// The query is read from m.Version and uses the same syntax as the go command:
+// an empty string or "latest", "upgrade", "patch", a canonical version, a
+// version prefix (v1.2), a comparison (<v1.3, >=v1.2.0) or a revision such as a
+// branch name or commit hash that the proxy can resolve.
+//
+// current is the version of the module that is already selected, or empty if
+// there is none. It is the base for "patch" queries and the lower bound for
+// "upgrade" queries.
+func (m Module) Query(proxyUrl *url.URL, current string) (string, error) {
+	query := m.Version
+	if query == "" {
+		query = QueryLatest
+	}
+
+	switch {
+	case query == "none":
+		return "", fmt.Errorf("Version query \"none\" is not supported for %v", m.Path)
+
+	case query == QueryLatest:
+		return m.queryMatching(proxyUrl, func(string) bool { return true }, true)
+
+	case query == QueryUpgrade:
+		version, err := m.queryMatching(proxyUrl, func(string) bool { return true }, true)
+		if err != nil {
+			return "", err
+		}
+		if current != "" && semver.Compare(current, version) > 0 {
+			return current, nil
+		}
+		return version, nil
+
+	case query == QueryPatch:
+		if current == "" {
+			return "", fmt.Errorf("Cannot query version \"patch\" of %v: no existing version is selected", m.Path)
+		}
+
+		versions, err := m.Versions(proxyUrl)
+		if err != nil {
+			return "", fmt.Errorf("Failed to query version \"patch\" of %v: %v", m.Path, err)
+		}
+
+		// Like the go command, patch stays on the current version when the
+		// list has no newer patch release
+		majorMinor := semver.MajorMinor(current)
+		version := m.selectAllowed(proxyUrl, versions, func(v string) bool {
+			return semver.MajorMinor(v) == majorMinor
+		})
+		if version == "" || semver.Compare(current, version) > 0 {
+			return current, nil
+		}
+		return version, nil
+
+	case strings.HasPrefix(query, "<=") || strings.HasPrefix(query, ">="):
+		return m.queryCompare(proxyUrl, query[:2], query[2:])
+
+	case strings.HasPrefix(query, "<") || strings.HasPrefix(query, ">"):
+		return m.queryCompare(proxyUrl, query[:1], query[1:])
+
+	case semver.IsValid(query) && isVersionPrefix(query):
+		return m.queryMatching(proxyUrl, func(v string) bool {
+			return matchesPrefix(v, query)
+		}, false)
+	}
+
+	// Canonical versions, branches, tags and commit hashes are resolved by the
+	// proxy's .info endpoint, which returns the canonical or pseudo-version
+	info, err := m.Info(proxyUrl, query)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve version %v of %v: %v", query, m.Path, err)
+	}
+
+	if !semver.IsValid(info.Version) || module.CanonicalVersion(info.Version) != info.Version {
+		return "", fmt.Errorf("Proxy resolved %v@%v to non-canonical version %q", m.Path, query, info.Version)
+	}
+
+	return info.Version, nil
+}
+
+// Get the proxy's .info file for a version or revision of this module
+func (m Module) Info(proxyUrl *url.URL, revision string) (*VersionInfo, error) {
+	escaped, err := module.EscapeVersion(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	infoPath := path.Join(m.EscapedPath(), "@v", escaped+string(ModFileTypeInfo))
+	infoUrl, err := proxyUrl.Parse(infoPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to build proxy Url: %v %v: %v", proxyUrl, infoPath, err)
+	}
+
+	return fetchInfo(infoUrl)
+}
+
+// Select the highest version from the proxy's list that passes match
+//
+// Releases are preferred over pre-releases. When nothing in the list matches
+// and allowPseudo is set, the proxy's @latest endpoint is used so that modules
+// without any tagged versions resolve to a pseudo-version.
+func (m Module) queryMatching(proxyUrl *url.URL, match func(string) bool, allowPseudo bool) (string, error) {
+	versions, err := m.Versions(proxyUrl)
+	if err != nil && !allowPseudo {
+		return "", err
+	}
+
+	// Versions are sorted from newest to oldest
+	if version := m.selectAllowed(proxyUrl, versions, match); version != "" {
+		return version, nil
+	}
+
+	if allowPseudo {
+		return m.LatestVersion(proxyUrl)
+	}
+
+	return "", fmt.Errorf("No matching versions for %v@%v", m.Path, m.Version)
+}
+
+// Resolve a comparison query such as <v1.2.0 or >=v1.3
+func (m Module) queryCompare(proxyUrl *url.URL, op string, bound string) (string, error) {
+	if !semver.IsValid(bound) {
+		return "", fmt.Errorf("Invalid version query %v@%v: %q is not a semantic version", m.Path, m.Version, bound)
+	}
+
+	versions, err := m.Versions(proxyUrl)
+	if err != nil {
+		return "", err
+	}
+
+	match := func(v string) bool {
+		cmp := semver.Compare(v, bound)
+		switch op {
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		}
+		return cmp >= 0
+	}
+
+	// Upper bounds select the highest matching version, lower bounds the lowest
+	if op == ">" || op == ">=" {
+		reversed := make([]string, 0, len(versions))
+		for i := len(versions) - 1; i >= 0; i-- {
+			reversed = append(reversed, versions[i])
+		}
+		versions = reversed
+	}
+
+	if version := m.selectAllowed(proxyUrl, versions, match); version != "" {
+		return version, nil
+	}
+
+	return "", fmt.Errorf("No matching versions for %v@%v", m.Path, m.Version)
+}
+
+// Select a version that passes match, skipping retracted versions
+//
+// Retracted versions are only selected when every matching version has been
+// retracted.
+func (m Module) selectAllowed(proxyUrl *url.URL, versions []string, match func(string) bool) string {
+	if status, err := m.Status(proxyUrl); err == nil {
+		version := selectVersion(versions, func(v string) bool {
+			retracted, _ := status.IsRetracted(v)
+			return !retracted && match(v)
+		})
+		if version != "" {
+			return version
+		}
+	}
+
+	return selectVersion(versions, match)
+}
+
+// Select the version that the latest query resolves to from a list
+//
+// This is the highest release, or the highest pre-release if there are no
+// releases. Versions retracted by status are skipped unless every version has
+// been retracted. Pseudo-versions are never selected.
+func SelectLatest(versions []string, status *ModuleStatus) string {
+	sorted := make([]string, len(versions))
+	copy(sorted, versions)
+	sort.Slice(sorted, func(a, b int) bool {
+		return semver.Compare(sorted[a], sorted[b]) > 0
+	})
+
+	latest := selectVersion(sorted, func(v string) bool {
+		retracted, _ := status.IsRetracted(v)
+		return !retracted
+	})
+	if latest == "" {
+		latest = selectVersion(sorted, func(string) bool { return true })
+	}
+
+	return latest
+}
+
+// Return the first release in versions that passes match, or the first
+// pre-release if no release matches
+func selectVersion(versions []string, match func(string) bool) string {
+	prerelease := ""
+	for _, v := range versions {
+		if !semver.IsValid(v) || module.IsPseudoVersion(v) || !match(v) {
+			continue
+		}
+
+		if semver.Prerelease(v) == "" {
+			return v
+		}
+
+		if prerelease == "" {
+			prerelease = v
+		}
+	}
+
+	return prerelease
+}
+
+// Test if a valid semantic version is an abbreviated prefix like v1 or v1.2
+func isVersionPrefix(v string) bool {
+	return semver.Prerelease(v) == "" &&
+		semver.Build(v) == "" &&
+		strings.Count(v, ".") < 2
+}
+
+// Test if version v begins with the version prefix
+func matchesPrefix(v string, prefix string) bool {
+	if strings.Count(prefix, ".") == 0 {
+		return semver.Major(v) == prefix
+	}
+	return semver.MajorMinor(v) == prefix
+}
+
+// Retrieve and decode a .info file
+func fetchInfo(infoUrl *url.URL) (*VersionInfo, error) {
+	infoResp, err := HttpGet(infoUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer infoResp.Close()
+
+	infoBytes, err := io.ReadAll(infoResp)
+	if err != nil {
+		return nil, err
+	}
+
+	info := new(VersionInfo)
+	if err := json.Unmarshal(infoBytes, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
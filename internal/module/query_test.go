@@ -0,0 +1,161 @@
+package module
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// A module served by the fake proxy
+type fakeModule struct {
+	versions []string
+	retract  []string
+	pseudo   string
+}
+
+// Serve the @v/list, .info, .mod and @latest files of modules
+func newFakeProxy(t *testing.T, modules map[string]fakeModule) *url.URL {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requestPath := strings.TrimPrefix(request.URL.Path, "/")
+		if modPath := strings.TrimSuffix(requestPath, "/@latest"); modPath != requestPath {
+			if fake, ok := modules[modPath]; ok && fake.pseudo != "" {
+				fmt.Fprintf(writer, `{"Version":%q,"Time":"2023-01-01T00:00:00Z"}`, fake.pseudo)
+				return
+			}
+		}
+
+		parts := strings.SplitN(requestPath, "/@v/", 2)
+		fake, ok := modules[parts[0]]
+		if len(parts) != 2 || !ok {
+			http.NotFound(writer, request)
+			return
+		}
+
+		switch {
+		case parts[1] == "list":
+			fmt.Fprint(writer, strings.Join(fake.versions, "\n"))
+		case strings.HasSuffix(parts[1], ".mod"):
+			fmt.Fprintf(writer, "module %v\n", parts[0])
+			for _, retract := range fake.retract {
+				fmt.Fprintf(writer, "retract %v\n", retract)
+			}
+		case parts[1] == "master.info":
+			fmt.Fprintf(writer, `{"Version":%q,"Time":"2023-01-01T00:00:00Z"}`, fake.pseudo)
+		case parts[1] == "short.info":
+			fmt.Fprint(writer, `{"Version":"v1.0","Time":"2023-01-01T00:00:00Z"}`)
+		case strings.HasSuffix(parts[1], ".info"):
+			version := strings.TrimSuffix(parts[1], ".info")
+			fmt.Fprintf(writer, `{"Version":%q,"Time":"2023-01-01T00:00:00Z"}`, version)
+		default:
+			http.NotFound(writer, request)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	proxyUrl, _ := url.Parse(server.URL + "/")
+	return proxyUrl
+}
+
+func TestQuery(t *testing.T) {
+	proxyUrl := newFakeProxy(t, map[string]fakeModule{
+		"example.com/a": {
+			versions: []string{
+				"v0.9.0", "v1.0.0", "v1.2.0", "v1.2.1", "v1.2.2", "v1.3.0", "v1.3.1",
+				"v1.4.0-rc.1", "v1.3.2-0.20230101000000-abcdefabcdef",
+			},
+			retract: []string{"v1.2.2", "v1.3.1"},
+			pseudo:  "v1.3.2-0.20230101000000-abcdefabcdef",
+		},
+		"example.com/untagged": {
+			pseudo: "v0.0.0-20230101000000-abcdefabcdef",
+		},
+		"example.com/retracted": {
+			versions: []string{"v1.0.0", "v1.1.0"},
+			retract:  []string{"[v1.0.0, v1.1.0]"},
+		},
+	})
+
+	tests := []struct {
+		path    string
+		query   string
+		current string
+		version string
+		err     string
+	}{
+		{"example.com/a", "", "", "v1.3.0", ""},
+		{"example.com/a", "latest", "", "v1.3.0", ""},
+		{"example.com/a", "upgrade", "v1.2.0", "v1.3.0", ""},
+		{"example.com/a", "upgrade", "v1.4.0-rc.1", "v1.4.0-rc.1", ""},
+		{"example.com/a", "patch", "v1.2.0", "v1.2.1", ""},
+		{"example.com/a", "patch", "v1.2.5", "v1.2.5", ""},
+		{"example.com/a", "patch", "v1.1.0", "v1.1.0", ""},
+		{"example.com/a", "patch", "", "", "no existing version is selected"},
+		{"example.com/a", "<v1.3", "", "v1.2.1", ""},
+		{"example.com/a", "<=v1.3.0", "", "v1.3.0", ""},
+		{"example.com/a", ">v1.0.0", "", "v1.2.0", ""},
+		{"example.com/a", ">=v1.2.2", "", "v1.3.0", ""},
+		{"example.com/a", ">v1.3.x", "", "", "not a semantic version"},
+		{"example.com/a", "v1.2", "", "v1.2.1", ""},
+		{"example.com/a", "v1.4", "", "v1.4.0-rc.1", ""},
+		{"example.com/a", "v1", "", "v1.3.0", ""},
+		{"example.com/a", "v2", "", "", "No matching versions"},
+		{"example.com/a", "v1.2.2", "", "v1.2.2", ""},
+		{"example.com/a", "master", "", "v1.3.2-0.20230101000000-abcdefabcdef", ""},
+		{"example.com/a", "short", "", "", "non-canonical version"},
+		{"example.com/a", "none", "", "", "not supported"},
+		{"example.com/untagged", "latest", "", "v0.0.0-20230101000000-abcdefabcdef", ""},
+		{"example.com/untagged", "upgrade", "v0.0.0-20220101000000-abcdefabcdef", "v0.0.0-20230101000000-abcdefabcdef", ""},
+		{"example.com/retracted", "latest", "", "v1.1.0", ""},
+		{"example.com/retracted", "v1.0", "", "v1.0.0", ""},
+		{"example.com/missing", "patch", "v1.0.0", "", "Failed to query version \"patch\""},
+		{"example.com/missing", "v1.2", "", "", "not found"},
+	}
+
+	for _, test := range tests {
+		m := Module{Path: test.path, Version: test.query}
+		version, err := m.Query(proxyUrl, test.current)
+		if test.err == "" && err != nil {
+			t.Errorf("Query(%v@%v, %q) failed: %v", test.path, test.query, test.current, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Query(%v@%v, %q) = %q, %v, expected an error containing %q", test.path, test.query, test.current, version, err, test.err)
+		} else if version != test.version {
+			t.Errorf("Query(%v@%v, %q) = %q, expected %q", test.path, test.query, test.current, version, test.version)
+		}
+	}
+}
+
+func TestSelectLatest(t *testing.T) {
+	tests := []struct {
+		versions []string
+		retract  []string
+		latest   string
+	}{
+		{[]string{"v1.0.0", "v1.2.0", "v1.1.0"}, nil, "v1.2.0"},
+		{[]string{"v1.0.0", "v1.1.0-rc.1"}, nil, "v1.0.0"},
+		{[]string{"v1.1.0-rc.1", "v1.1.0-rc.2"}, nil, "v1.1.0-rc.2"},
+		{[]string{"v1.0.0", "v1.2.0"}, []string{"v1.2.0"}, "v1.0.0"},
+		{[]string{"v1.0.0", "v1.2.0"}, []string{"[v1.0.0, v1.2.0]"}, "v1.2.0"},
+		{[]string{"v1.0.0", "v1.0.1-0.20230101000000-abcdefabcdef"}, nil, "v1.0.0"},
+		{[]string{"v0.0.0-20230101000000-abcdefabcdef"}, nil, ""},
+		{nil, nil, ""},
+	}
+
+	for _, test := range tests {
+		modFile := "module example.com/a\n"
+		for _, retract := range test.retract {
+			modFile += "retract " + retract + "\n"
+		}
+
+		status, err := ParseModuleStatus("example.com/a", "v1.2.0", []byte(modFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if latest := SelectLatest(test.versions, status); latest != test.latest {
+			t.Errorf("SelectLatest(%v) retracting %v = %q, expected %q", test.versions, test.retract, latest, test.latest)
+		}
+	}
+}
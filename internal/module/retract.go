@@ -0,0 +1,102 @@
+package module
+
+import (
+	"fmt"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+	"io"
+	"net/url"
+)
+
+// The retractions and deprecation notice published by a module's author
+//
+// Both are read from the go.mod file of the module's latest version.
+type ModuleStatus struct {
+	// Version of the go.mod file that the status was read from
+	Version string
+
+	// Deprecation message, empty if the module is not deprecated
+	Deprecated string
+
+	Retract []*modfile.Retract
+
+	// Versions listed by the proxy's @v/list
+	Versions []string
+}
+
+// Read the retract directives and deprecation comment from a go.mod file
+func ParseModuleStatus(modPath string, version string, modFileBytes []byte) (*ModuleStatus, error) {
+	modDetails, err := modfile.ParseLax(modPath, modFileBytes, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse gomod file for %s@%s: %v", modPath, version, err)
+	}
+
+	status := &ModuleStatus{
+		Version: version,
+		Retract: modDetails.Retract,
+	}
+	if modDetails.Module != nil {
+		status.Deprecated = modDetails.Module.Deprecated
+	}
+
+	return status, nil
+}
+
+// Test if a version is retracted, and return the author's rationale
+func (s *ModuleStatus) IsRetracted(version string) (bool, string) {
+	if s == nil {
+		return false, ""
+	}
+
+	for _, r := range s.Retract {
+		if semver.Compare(r.Low, version) <= 0 && semver.Compare(version, r.High) <= 0 {
+			return true, r.Rationale
+		}
+	}
+
+	return false, ""
+}
+
+// Get the retractions and deprecation notice for a module from a proxy
+//
+// Like the go command, the status is read from the go.mod file of the
+// latest version without regard to retractions: the highest release, or the
+// highest pre-release if the module has no releases.
+func (m Module) Status(proxyUrl *url.URL) (*ModuleStatus, error) {
+	versions, err := m.Versions(proxyUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := Module{Path: m.Path}
+	if latest.Version = SelectLatest(versions, nil); latest.Version == "" {
+		if latest.Version, err = m.LatestVersion(proxyUrl); err != nil {
+			return nil, err
+		}
+	}
+
+	modFile := latest.ModuleFile()
+	modUrl, err := proxyUrl.Parse(modFile.ProxyPath)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to build proxy Url: %v %v: %v", proxyUrl, modFile.ProxyPath, err)
+	}
+
+	modResp, err := HttpGet(modUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer modResp.Close()
+
+	modFileBytes, err := io.ReadAll(modResp)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := ParseModuleStatus(m.Path, latest.Version, modFileBytes)
+	if err != nil {
+		return nil, err
+	}
+	status.Versions = versions
+
+	return status, nil
+}
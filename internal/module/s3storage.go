@@ -0,0 +1,529 @@
+package module
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Objects are read from S3 in blocks of at least this size
+const s3ReadAhead = 4 << 20
+
+// Storage in an S3-compatible object store, such as AWS S3 or MinIO
+//
+// Objects use the same names as files in a module set, below an optional key
+// prefix. Requests use path-style addressing and are signed with AWS
+// Signature Version 4.
+type S3Storage struct {
+	Endpoint *url.URL
+	Bucket   string
+	Prefix   string
+	Region   string
+
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+}
+
+// Create a Storage for an S3 url of the form s3://bucket/prefix
+//
+// The endpoint and region can be set with the endpoint and region query
+// parameters, or the AWS_ENDPOINT_URL and AWS_REGION environment variables.
+// Credentials are read from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN. Requests are not signed when there are no credentials.
+func NewS3Storage(spec string) (*S3Storage, error) {
+	storageUrl, err := url.Parse(spec)
+	if err != nil || storageUrl.Scheme != "s3" || storageUrl.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 storage %q, expected s3://bucket/prefix", spec)
+	}
+
+	s := &S3Storage{
+		Bucket:       storageUrl.Host,
+		Prefix:       strings.Trim(storageUrl.Path, "/"),
+		Region:       storageUrl.Query().Get("region"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       http.DefaultClient,
+	}
+
+	if s.Region == "" {
+		s.Region = os.Getenv("AWS_REGION")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+
+	endpoint := storageUrl.Query().Get("endpoint")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.Region)
+	}
+
+	if s.Endpoint, err = url.Parse(endpoint); err != nil || s.Endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q", endpoint)
+	}
+
+	return s, nil
+}
+
+// Get the object key of a stored file
+func (s *S3Storage) key(name string) string {
+	name = cleanName(name)
+	if name == "." {
+		name = ""
+	}
+
+	if s.Prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(s.Prefix+"/"+name, "/")
+}
+
+func (s *S3Storage) Stat(name string) (os.FileInfo, error) {
+	resp, err := s.do(http.MethodHead, s.key(name), nil, nil, "")
+	if err != nil {
+		if os.IsNotExist(err) {
+			if entries, listErr := s.List(name); listErr == nil && len(entries) > 0 {
+				return storageFileInfo{name: path.Base(cleanName(name)), dir: true}, nil
+			}
+		}
+		return nil, err
+	}
+	resp.Body.Close()
+
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return storageFileInfo{
+		name:    path.Base(cleanName(name)),
+		size:    resp.ContentLength,
+		modTime: modTime,
+	}, nil
+}
+
+func (s *S3Storage) Open(name string) (StorageFile, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return nil, fmt.Errorf("%v is a directory", name)
+	}
+
+	object := &s3Object{storage: s, key: s.key(name), size: info.Size()}
+	return sectionFile{
+		SectionReader: io.NewSectionReader(object, 0, info.Size()),
+		info:          info.(storageFileInfo),
+	}, nil
+}
+
+// New files are written to a temporary file, then uploaded on Commit
+func (s *S3Storage) Create(name string) (StorageWriter, error) {
+	tmp, err := os.CreateTemp("", "goff-s3-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Writer{File: tmp, storage: s, key: s.key(name)}, nil
+}
+
+// Deleting a missing object succeeds in S3, so the object is checked first
+func (s *S3Storage) Remove(name string) error {
+	if _, err := s.Stat(name); err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodDelete, s.key(name), nil, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+type s3ListResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+}
+
+func (s *S3Storage) List(dir string) ([]os.FileInfo, error) {
+	prefix := s.key(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var entries []os.FileInfo
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("delimiter", "/")
+	query.Set("prefix", prefix)
+
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid S3 list response: %v", err)
+		}
+
+		for _, p := range result.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			entries = append(entries, storageFileInfo{name: name, dir: true})
+		}
+
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, prefix)
+			if name == "" || strings.HasPrefix(name, ".") {
+				continue
+			}
+			entries = append(entries, storageFileInfo{
+				name:    name,
+				size:    object.Size,
+				modTime: object.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	if len(entries) == 0 && cleanName(dir) != "." {
+		return nil, notExist("list", dir)
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].Name() < entries[b].Name()
+	})
+
+	return entries, nil
+}
+
+// Create a presigned url that allows anyone to download a stored file until
+// it expires
+func (s *S3Storage) PresignGet(name string, expires time.Duration) (string, error) {
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return "", fmt.Errorf("Presigned urls for %v require S3 credentials", s.Bucket)
+	}
+
+	now := time.Now().UTC()
+	objectUrl := s.objectUrl(s.key(name))
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.AccessKey+"/"+s.scope(now))
+	query.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if s.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := http.Header{}
+	headers.Set("Host", objectUrl.Host)
+	signature := s.signature(now, http.MethodGet, objectUrl.EscapedPath(), query, headers, "UNSIGNED-PAYLOAD")
+	query.Set("X-Amz-Signature", signature)
+
+	objectUrl.RawQuery = canonicalQuery(query)
+	return objectUrl.String(), nil
+}
+
+// Get the path-style url of an object
+func (s *S3Storage) objectUrl(key string) *url.URL {
+	objectPath := strings.TrimSuffix(s.Endpoint.Path, "/") + "/" + s.Bucket
+	if key != "" {
+		objectPath += "/" + key
+	}
+
+	objectUrl := *s.Endpoint
+	objectUrl.Path = objectPath
+	objectUrl.RawPath = uriEncode(objectPath, false)
+	objectUrl.RawQuery = ""
+	return &objectUrl
+}
+
+// Send a signed request for an object, or for the bucket if key is empty
+//
+// Responses with a status other than 200, 204 or 206 are returned as errors.
+func (s *S3Storage) do(method string, key string, query url.Values, body io.ReadSeeker, rangeHeader string) (*http.Response, error) {
+	objectUrl := s.objectUrl(key)
+	objectUrl.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, objectUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		hash := sha256.New()
+		size, err := io.Copy(hash, body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		payloadHash = hex.EncodeToString(hash.Sum(nil))
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+	}
+
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	s.sign(req, payloadHash)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNoContent {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, notExist(strings.ToLower(method), key)
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("S3 %v %v failed with %v: %s", method, objectUrl, resp.Status, msg)
+}
+
+// SHA-256 hash of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Add AWS Signature Version 4 headers to a request
+func (s *S3Storage) sign(req *http.Request, payloadHash string) {
+	if s.AccessKey == "" {
+		return
+	}
+
+	now := time.Now().UTC()
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	signature := s.signature(now, req.Method, req.URL.EscapedPath(), req.URL.Query(), req.Header, payloadHash)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, s.scope(now), strings.Join(signedHeaders(req.Header), ";"), signature))
+	req.Header.Del("Host")
+}
+
+// Get the credential scope of a signature
+func (s *S3Storage) scope(now time.Time) string {
+	return fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s.Region)
+}
+
+// Calculate an AWS Signature Version 4 signature
+func (s *S3Storage) signature(now time.Time, method string, escapedPath string, query url.Values, headers http.Header, payloadHash string) string {
+	signed := signedHeaders(headers)
+	var canonicalHeaders strings.Builder
+	for _, name := range signed {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(headers.Get(name)))
+	}
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapedPath,
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		strings.Join(signed, ";"),
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		s.scope(now),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	key = hmacSha256(key, s.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSha256(key, stringToSign))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Get the sorted, lower-case names of the headers included in a signature
+func signedHeaders(headers http.Header) []string {
+	var names []string
+	for name := range headers {
+		lower := strings.ToLower(name)
+		if lower == "host" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Encode query parameters in the sorted form used by signatures
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// Percent-encode everything except unreserved characters, and optionally /
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+
+	return encoded.String()
+}
+
+// An io.ReaderAt for an object that reads ahead with ranged requests
+type s3Object struct {
+	storage *S3Storage
+	key     string
+	size    int64
+
+	sync.Mutex
+	buf       []byte
+	bufOffset int64
+}
+
+func (o *s3Object) ReadAt(p []byte, off int64) (int, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	n := 0
+	for n < len(p) && off < o.size {
+		if off < o.bufOffset || off >= o.bufOffset+int64(len(o.buf)) {
+			if err := o.fill(off, int64(len(p)-n)); err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(p[n:], o.buf[off-o.bufOffset:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read a block of the object starting at off
+func (o *s3Object) fill(off int64, want int64) error {
+	if want < s3ReadAhead {
+		want = s3ReadAhead
+	}
+
+	end := off + want
+	if end > o.size {
+		end = o.size
+	}
+
+	resp, err := o.storage.do(http.MethodGet, o.key, nil, nil, fmt.Sprintf("bytes=%d-%d", off, end-1))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(buf)) != end-off {
+		return fmt.Errorf("S3 returned %v bytes of %v for %v", len(buf), end-off, o.key)
+	}
+
+	o.buf = buf
+	o.bufOffset = off
+	return nil
+}
+
+type s3Writer struct {
+	*os.File
+	storage *S3Storage
+	key     string
+}
+
+func (w *s3Writer) Commit() error {
+	defer w.Abort()
+
+	if _, err := w.File.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := w.storage.do(http.MethodPut, w.key, nil, w.File, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+func (w *s3Writer) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
@@ -0,0 +1,325 @@
+package module
+
+import (
+	"errors"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Storage holds module files using the layout of a module set
+//
+// Names are slash-separated paths relative to the root of the storage, such
+// as the FilePath of a ModuleFile. Module sets and server roots use the same
+// layout, so any Storage can be downloaded to, uploaded to or served.
+type Storage interface {
+	// Get information about a stored file
+	Stat(name string) (os.FileInfo, error)
+
+	// Open a stored file for reading
+	Open(name string) (StorageFile, error)
+
+	// Create a file that is not visible to readers until it is committed
+	Create(name string) (StorageWriter, error)
+
+	// List the files and directories in a directory
+	List(dir string) ([]os.FileInfo, error)
+}
+
+// A file opened for reading from a Storage
+type StorageFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// A new file being written to a Storage
+type StorageWriter interface {
+	io.Writer
+
+	// Atomically publish the file, replacing any file with the same name
+	Commit() error
+
+	// Discard the file
+	Abort() error
+}
+
+// Open the Storage described by spec
+//
+// Specs use the following forms:
+//
+//	mem:              a new, empty in-memory storage
+//	s3://bucket/path  objects in an S3-compatible bucket, see NewS3Storage
+//	path/to/set.tar   an existing tar archive
+//	path/to/set.zip   a read-only zip bundle
+//	path/to/dir       a directory
+func OpenStorage(spec string) (Storage, error) {
+	if strings.HasSuffix(spec, ".tar") {
+		return OpenTarStorage(spec)
+	}
+
+	return openStorage(spec)
+}
+
+// Open the Storage described by spec to write modules to it
+//
+// Unlike OpenStorage, a tar archive is created if it does not exist.
+func CreateStorage(spec string) (Storage, error) {
+	if strings.HasSuffix(spec, ".tar") {
+		return CreateTarStorage(spec)
+	}
+
+	return openStorage(spec)
+}
+
+func openStorage(spec string) (Storage, error) {
+	switch {
+	case spec == "mem:":
+		return NewMemoryStorage(), nil
+	case strings.HasPrefix(spec, "s3://"):
+		return NewS3Storage(spec)
+	case strings.HasSuffix(spec, ".zip"):
+		return OpenZipBundle(spec)
+	}
+
+	return NewFileStorage(spec), nil
+}
+
+// Test if a Storage spec refers to a tar or zip bundle
+func IsBundle(spec string) bool {
+	return strings.HasSuffix(spec, ".tar") || strings.HasSuffix(spec, ".zip")
+}
+
+// Open an existing tar or zip bundle of module files read-only
+func OpenBundle(spec string) (Storage, error) {
+	if strings.HasSuffix(spec, ".zip") {
+		return OpenZipBundle(spec)
+	}
+
+	return OpenTarBundle(spec)
+}
+
+// Get the versions of a module that have an .info file in a Storage
+func StoredVersions(s Storage, modPath string) ([]string, error) {
+	escapedPath, err := module.EscapePath(modPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := s.List(escapedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		version, err := FileVersion(entry.Name(), ModFileTypeInfo)
+		if err != nil || !semver.IsValid(version) {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// Get every module version that has an .info file in a Storage
+func StoredModules(s Storage) ([]Module, error) {
+	var modules []Module
+	err := Walk(s, ".", func(name string, info os.FileInfo) error {
+		version, err := FileVersion(path.Base(name), ModFileTypeInfo)
+		if err != nil || !semver.IsValid(version) {
+			return nil
+		}
+
+		modPath, err := module.UnescapePath(path.Dir(name))
+		if err != nil {
+			return nil
+		}
+
+		modules = append(modules, Module{Path: modPath, Version: version})
+		return nil
+	})
+
+	return modules, err
+}
+
+// A Storage that can give clients temporary, direct access to its files
+type Presigner interface {
+	// Create a url that allows anyone to download a file until it expires
+	PresignGet(name string, expires time.Duration) (string, error)
+}
+
+// A Storage that can delete files
+type Remover interface {
+	// Delete a stored file
+	Remove(name string) error
+}
+
+// Read the contents of a stored file
+func ReadFile(s Storage, name string) ([]byte, error) {
+	file, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// Store a file with the provided contents
+func WriteFile(s Storage, name string, data []byte) error {
+	out, err := s.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(data); err != nil {
+		out.Abort()
+		return err
+	}
+
+	return out.Commit()
+}
+
+// Copy a file from one Storage to another
+func CopyFile(dst Storage, src Storage, name string) error {
+	in, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := dst.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Abort()
+		return err
+	}
+
+	return out.Commit()
+}
+
+// Call fn for every file stored beneath dir, in lexical order
+func Walk(s Storage, dir string, fn func(name string, info os.FileInfo) error) error {
+	entries, err := s.List(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			err = Walk(s, name, fn)
+		} else {
+			err = fn(name, entry)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returned when creating files in read-only storage
+var ErrReadOnly = errors.New("storage is read-only")
+
+// Return a not exist error for a stored file
+func notExist(op string, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// Clean a storage name and make it relative to the storage root
+func cleanName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// An os.FileInfo for storage that is not backed by a filesystem
+type storageFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi storageFileInfo) Name() string       { return fi.name }
+func (fi storageFileInfo) Size() int64        { return fi.size }
+func (fi storageFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi storageFileInfo) IsDir() bool        { return fi.dir }
+func (fi storageFileInfo) Sys() interface{}   { return nil }
+
+func (fi storageFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// A StorageFile for file contents that are available through an io.ReaderAt
+type sectionFile struct {
+	*io.SectionReader
+	info storageFileInfo
+}
+
+func (f sectionFile) Close() error               { return nil }
+func (f sectionFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+// List the entries of dir from a sorted list of all stored file names
+//
+// Used by storage that does not have real directories.
+func listNames(names []string, dir string, stat func(string) storageFileInfo) ([]os.FileInfo, error) {
+	dir = cleanName(dir)
+	prefix := dir + "/"
+	if dir == "." {
+		prefix = ""
+	}
+
+	// Names are sorted, so the directory's contents are contiguous
+	first := sort.SearchStrings(names, prefix)
+
+	var entries []os.FileInfo
+	seen := make(map[string]bool)
+	for _, name := range names[first:] {
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+
+		child := strings.TrimPrefix(name, prefix)
+		if idx := strings.Index(child, "/"); idx >= 0 {
+			child = child[:idx]
+			if !seen[child] {
+				seen[child] = true
+				entries = append(entries, storageFileInfo{name: child, dir: true})
+			}
+			continue
+		}
+
+		entries = append(entries, stat(name))
+	}
+
+	if len(entries) == 0 && dir != "." {
+		return nil, notExist("list", dir)
+	}
+
+	return entries, nil
+}
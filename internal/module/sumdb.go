@@ -3,6 +3,7 @@ package module
 import (
 	"bytes"
 	"fmt"
+	"github.com/haboustak/goff/internal/logging"
 	"golang.org/x/mod/sumdb"
 	"io"
 	"net/url"
@@ -11,6 +12,7 @@ import (
 // An in-memory implementation of SumDbClient
 type memoryClient struct {
 	base  *url.URL
+	log   *logging.Logger
 	state chan memoryClientState
 }
 
@@ -21,9 +23,12 @@ type memoryClientState struct {
 }
 
 // Returns a new SubDbClient for the given url
-func NewClient(url *url.URL) *sumdb.Client {
+//
+// Security errors reported by the client are written to the logger.
+func NewClient(url *url.URL, logger *logging.Logger) *sumdb.Client {
 	client := &memoryClient{
 		base:  url,
+		log:   logger,
 		state: make(chan memoryClientState, 1),
 	}
 	state := memoryClientState{
@@ -94,9 +99,10 @@ func (c *memoryClient) WriteCache(file string, value []byte) {
 	c.state <- state
 }
 
-func (*memoryClient) Log(msg string) {
+func (c *memoryClient) Log(msg string) {
+	c.log.Debug(msg, "sumdb", c.base)
 }
 
-func (*memoryClient) SecurityError(msg string) {
-	fmt.Println(msg)
+func (c *memoryClient) SecurityError(msg string) {
+	c.log.Security(msg, "sumdb", c.base)
 }
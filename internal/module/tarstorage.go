@@ -0,0 +1,272 @@
+package module
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage in an uncompressed tar archive
+//
+// The archive is indexed when it is opened, so files can be read without
+// scanning the archive. New files are appended to the end of the archive.
+// When an archive contains several files with the same name, the last one is
+// used.
+type TarStorage struct {
+	path     string
+	file     *os.File
+	index    map[string]tarEntry
+	names    []string
+	end      int64
+	readOnly bool
+	sync.RWMutex
+}
+
+type tarEntry struct {
+	offset  int64
+	size    int64
+	modTime time.Time
+}
+
+// Open an existing tar archive for storing module files
+//
+// Archives that cannot be opened for writing are opened read-only.
+func OpenTarStorage(archivePath string) (*TarStorage, error) {
+	return openTarStorage(archivePath, 0)
+}
+
+// Open a tar archive for storing module files, creating it if it does not
+// exist
+func CreateTarStorage(archivePath string) (*TarStorage, error) {
+	return openTarStorage(archivePath, os.O_CREATE)
+}
+
+func openTarStorage(archivePath string, flags int) (*TarStorage, error) {
+	readOnly := false
+	file, err := os.OpenFile(archivePath, os.O_RDWR|flags, 0644)
+	if os.IsPermission(err) {
+		file, err = os.Open(archivePath)
+		readOnly = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newTarStorage(archivePath, file, readOnly)
+}
+
+// Open an existing tar archive of module files without modifying it
+func OpenTarBundle(archivePath string) (*TarStorage, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTarStorage(archivePath, file, true)
+}
+
+func newTarStorage(archivePath string, file *os.File, readOnly bool) (*TarStorage, error) {
+	s := &TarStorage{
+		path:     archivePath,
+		file:     file,
+		index:    make(map[string]tarEntry),
+		readOnly: readOnly,
+	}
+
+	if err := s.readIndex(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to read tar archive %v: %v", archivePath, err)
+	}
+
+	return s, nil
+}
+
+// Build the index of regular files in the archive
+func (s *TarStorage) readIndex() error {
+	reader := tar.NewReader(s.file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		// The reader does not read ahead, so the file is positioned at the
+		// start of the entry's contents
+		offset, err := s.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		s.end = offset + (header.Size+511)/512*512
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := cleanName(header.Name)
+		if _, exists := s.index[name]; !exists {
+			s.names = append(s.names, name)
+		}
+		s.index[name] = tarEntry{
+			offset:  offset,
+			size:    header.Size,
+			modTime: header.ModTime,
+		}
+	}
+	sort.Strings(s.names)
+
+	return nil
+}
+
+// Close the archive
+func (s *TarStorage) Close() error {
+	return s.file.Close()
+}
+
+func (s *TarStorage) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, ok := s.index[name]; ok {
+		return s.stat(name), nil
+	}
+
+	if entries, err := listNames(s.names, name, s.stat); err == nil && len(entries) > 0 {
+		return storageFileInfo{name: path.Base(name), dir: true}, nil
+	}
+
+	return nil, notExist("stat", name)
+}
+
+func (s *TarStorage) Open(name string) (StorageFile, error) {
+	name = cleanName(name)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	entry, ok := s.index[name]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+
+	return sectionFile{
+		SectionReader: io.NewSectionReader(s.file, entry.offset, entry.size),
+		info:          s.stat(name),
+	}, nil
+}
+
+// New files are written to a temporary file, then appended to the archive
+// on Commit
+func (s *TarStorage) Create(name string) (StorageWriter, error) {
+	if s.readOnly {
+		return nil, &os.PathError{Op: "create", Path: name, Err: ErrReadOnly}
+	}
+
+	tmp, err := os.CreateTemp("", "goff-tar-*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarWriter{File: tmp, storage: s, name: cleanName(name)}, nil
+}
+
+func (s *TarStorage) List(dir string) ([]os.FileInfo, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	return listNames(s.names, dir, s.stat)
+}
+
+// Get information about a stored file, the caller must hold the lock
+func (s *TarStorage) stat(name string) storageFileInfo {
+	entry := s.index[name]
+	return storageFileInfo{
+		name:    path.Base(name),
+		size:    entry.size,
+		modTime: entry.modTime,
+	}
+}
+
+// Append a file to the end of the archive
+func (s *TarStorage) append(name string, contents *os.File) error {
+	info, err := contents.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := contents.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, err := s.file.Seek(s.end, io.SeekStart); err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     info.Size(),
+		Mode:     0644,
+		ModTime:  time.Now().Truncate(time.Second),
+	}
+
+	writer := tar.NewWriter(s.file)
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(writer, contents); err != nil {
+		return err
+	}
+
+	// Close pads the contents and writes the end of archive marker, which
+	// the next file will overwrite
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if _, exists := s.index[name]; !exists {
+		s.names = append(s.names, name)
+		sort.Strings(s.names)
+	}
+	s.index[name] = tarEntry{
+		offset:  offset,
+		size:    header.Size,
+		modTime: header.ModTime,
+	}
+	s.end = offset + (header.Size+511)/512*512
+
+	return s.file.Sync()
+}
+
+type tarWriter struct {
+	*os.File
+	storage *TarStorage
+	name    string
+}
+
+func (w *tarWriter) Commit() error {
+	defer w.Abort()
+	return w.storage.append(w.name, w.File)
+}
+
+func (w *tarWriter) Abort() error {
+	w.File.Close()
+	return os.Remove(w.File.Name())
+}
@@ -0,0 +1,258 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Module path used by the go command to download Go toolchains
+const ToolchainPath = "golang.org/toolchain"
+
+// Toolchains are only distributed as modules since Go 1.21
+const firstToolchainVersion = "1.21"
+
+// The go and toolchain directives of a go.mod file
+type GoDirectives struct {
+	Go        string
+	Toolchain string
+}
+
+// Read the go and toolchain directives from the contents of a go.mod file
+//
+// The directives are read directly because the lax go.mod parser truncates
+// go versions to the language version and ignores toolchain lines.
+func ParseGoDirectives(modFileBytes []byte) GoDirectives {
+	var d GoDirectives
+
+	scanner := bufio.NewScanner(bytes.NewReader(modFileBytes))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "go":
+			d.Go = fields[1]
+		case "toolchain":
+			d.Toolchain = fields[1]
+		}
+	}
+
+	return d
+}
+
+// Get the name of the toolchain required to satisfy the directives
+//
+// Returns a toolchain name such as go1.21.3, or an empty string if the
+// directives do not require a toolchain that is distributed as a module.
+func (d GoDirectives) ToolchainName() string {
+	name := ""
+	if d.Go != "" {
+		name = "go" + d.Go
+	}
+
+	if strings.HasPrefix(d.Toolchain, "go") && CompareGoVersions(d.Toolchain[2:], d.Go) > 0 {
+		name = d.Toolchain
+	}
+
+	if name == "" || CompareGoVersions(name[2:], firstToolchainVersion) < 0 {
+		return ""
+	}
+
+	return name
+}
+
+// Get the toolchain modules needed for a toolchain on each target platform
+//
+// Platforms are given as GOOS/GOARCH pairs. In addition to the named
+// toolchain, the most recent patch release of the same Go version is
+// included, because the go command prefers it when switching toolchains.
+func ToolchainModules(proxyUrl *url.URL, name string, platforms []string) ([]Module, error) {
+	goVersion := strings.TrimPrefix(name, "go")
+	if !strings.HasPrefix(name, "go") || !isGoVersion(goVersion) {
+		return nil, fmt.Errorf("Invalid toolchain name %q", name)
+	}
+
+	tc := Module{Path: ToolchainPath}
+	available, err := tc.Versions(proxyUrl)
+	if err != nil {
+		available = nil
+	}
+
+	var modules []Module
+	for _, platform := range platforms {
+		target := strings.Split(platform, "/")
+		if len(target) != 2 || target[0] == "" || target[1] == "" {
+			return nil, fmt.Errorf("Invalid toolchain platform %q, expected GOOS/GOARCH", platform)
+		}
+		suffix := "." + target[0] + "-" + target[1]
+
+		// Language versions like 1.22 have no toolchain of their own
+		if _, _, patch, _ := parseGoVersion(goVersion); patch != languageVersion {
+			modules = append(modules, Module{Path: ToolchainPath, Version: toolchainVersion(name, suffix)})
+		}
+
+		latest := latestPatchRelease(available, goVersion, suffix)
+		if latest != "" && latest != toolchainVersion(name, suffix) {
+			modules = append(modules, Module{Path: ToolchainPath, Version: latest})
+		}
+	}
+
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("Proxy has no toolchain modules for %v", name)
+	}
+
+	return modules, nil
+}
+
+// Get the toolchain module version for a toolchain name and platform suffix
+func toolchainVersion(name string, suffix string) string {
+	return "v0.0.1-" + name + suffix
+}
+
+// Get the Go version of a toolchain module version, such as 1.21.3 for
+// v0.0.1-go1.21.3.linux-amd64, or "" if it is not a toolchain version
+func ToolchainGoVersion(version string) string {
+	if !strings.HasPrefix(version, "v0.0.1-go") {
+		return ""
+	}
+
+	goVersion := strings.TrimPrefix(version, "v0.0.1-go")
+	if i := strings.LastIndex(goVersion, "."); i > 0 {
+		goVersion = goVersion[:i]
+	}
+
+	if !isGoVersion(goVersion) {
+		return ""
+	}
+
+	return goVersion
+}
+
+// Find the newest release toolchain for a platform in the same
+// major.minor series as goVersion
+func latestPatchRelease(versions []string, goVersion string, suffix string) string {
+	major, minor, _, _ := parseGoVersion(goVersion)
+	series := fmt.Sprintf("%d.%d.", major, minor)
+
+	latest := ""
+	latestGo := ""
+	for _, v := range versions {
+		if !strings.HasPrefix(v, "v0.0.1-go") || !strings.HasSuffix(v, suffix) {
+			continue
+		}
+
+		candidate := strings.TrimSuffix(strings.TrimPrefix(v, "v0.0.1-go"), suffix)
+		if !strings.HasPrefix(candidate, series) || !isGoVersion(candidate) {
+			continue
+		}
+
+		if _, _, _, pre := parseGoVersion(candidate); pre != "" {
+			continue
+		}
+
+		if latest == "" || CompareGoVersions(candidate, latestGo) > 0 {
+			latest = v
+			latestGo = candidate
+		}
+	}
+
+	return latest
+}
+
+// Compare two Go versions such as 1.21, 1.21rc1 and 1.21.3
+//
+// The result is -1, 0 or 1 like semver.Compare. Language versions (1.21)
+// sort before their pre-releases (1.21rc1), which sort before their
+// releases (1.21.0). An empty or invalid version is less than all valid ones.
+func CompareGoVersions(a, b string) int {
+	validA, validB := isGoVersion(a), isGoVersion(b)
+	if !validA || !validB {
+		switch {
+		case validA:
+			return 1
+		case validB:
+			return -1
+		}
+		return 0
+	}
+
+	aMajor, aMinor, aPatch, aPre := parseGoVersion(a)
+	bMajor, bMinor, bPatch, bPre := parseGoVersion(b)
+	for _, cmp := range []int{aMajor - bMajor, aMinor - bMinor, aPatch - bPatch} {
+		if cmp < 0 {
+			return -1
+		} else if cmp > 0 {
+			return 1
+		}
+	}
+
+	return strings.Compare(aPre, bPre)
+}
+
+// Test if v is a valid Go version
+func isGoVersion(v string) bool {
+	major, _, _, _ := parseGoVersion(v)
+	return major > 0
+}
+
+// Patch numbers used for Go versions without a patch release
+const (
+	languageVersion   = -2
+	preReleaseVersion = -1
+)
+
+// Split a Go version into comparable parts
+//
+// Language versions have a patch of languageVersion, pre-releases have a
+// patch of preReleaseVersion and a pre-release string that sorts
+// alpha < beta < rc. Invalid versions have a major version of 0.
+func parseGoVersion(v string) (major int, minor int, patch int, pre string) {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, 0, ""
+	}
+
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 1 {
+		return 0, 0, 0, ""
+	}
+
+	minorStr := parts[1]
+	patch = languageVersion
+	for i, kind := range []string{"alpha", "beta", "rc"} {
+		if idx := strings.Index(minorStr, kind); idx > 0 {
+			n, err := strconv.Atoi(minorStr[idx+len(kind):])
+			if err != nil || len(parts) > 2 {
+				return 0, 0, 0, ""
+			}
+			pre = fmt.Sprintf("%d%04d", i, n)
+			minorStr = minorStr[:idx]
+			patch = preReleaseVersion
+			break
+		}
+	}
+
+	minor, err = strconv.Atoi(minorStr)
+	if err != nil || minor < 0 {
+		return 0, 0, 0, ""
+	}
+
+	if len(parts) > 2 {
+		if patch, err = strconv.Atoi(parts[2]); err != nil || patch < 0 {
+			return 0, 0, 0, ""
+		}
+	}
+
+	return major, minor, patch, pre
+}
@@ -0,0 +1,100 @@
+package module
+
+import (
+	"archive/zip"
+	"fmt"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+	"io"
+	"os"
+	"strings"
+)
+
+// A module zip file that is not safe to extract
+type ZipError struct {
+	Module Module
+	Err    error
+}
+
+func (e *ZipError) Error() string {
+	return fmt.Sprintf("Invalid zip file for %v: %v", e.Module, e.Err)
+}
+
+// Check that a module zip file follows the rules of the go command
+//
+// The file must be smaller than the go command's size limits, and its files
+// must have valid paths within the module, no case-insensitive collisions,
+// and no vendored packages. Files directly in a vendor directory, such as
+// vendor/modules.txt, are allowed.
+func checkZip(m Module, zipFile string) error {
+	checked, err := modzip.CheckZip(module.Version{Path: m.Path, Version: m.Version}, zipFile)
+	if err == nil {
+		err = checked.Err()
+	}
+
+	if err != nil {
+		return &ZipError{m, err}
+	}
+
+	// CheckZip does not look at vendor directories, which the go command
+	// leaves out of the zip files it creates
+	reader, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return &ZipError{m, err}
+	}
+	defer reader.Close()
+
+	prefix := m.Path + "@" + m.Version + "/"
+	for _, file := range reader.File {
+		if name := strings.TrimPrefix(file.Name, prefix); isVendoredPackage(name) {
+			return &ZipError{m, fmt.Errorf("%v is a vendored package file", name)}
+		}
+	}
+
+	return nil
+}
+
+// Test if a file belongs to a vendored package, like the go command does
+func isVendoredPackage(name string) bool {
+	var i int
+	if strings.HasPrefix(name, "vendor/") {
+		i += len("vendor/")
+	} else if j := strings.Index(name, "/vendor/"); j >= 0 {
+		i += j + len("/vendor/")
+	} else {
+		return false
+	}
+
+	return strings.Contains(name[i:], "/")
+}
+
+// Check that the stored zip file of a module follows the rules of the go
+// command
+func (m Module) CheckZip(store Storage) error {
+	name := m.ZipFile().FilePath
+	if files, isDir := store.(*FileStorage); isDir {
+		if _, err := os.Stat(files.FilePath(name)); err != nil {
+			return err
+		}
+		return checkZip(m, files.FilePath(name))
+	}
+
+	contents, err := store.Open(name)
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+
+	tmp, err := os.CreateTemp("", "goff-zip-*")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %v: %v", name, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, io.LimitReader(contents, modzip.MaxZipFile+1)); err != nil {
+		return fmt.Errorf("Failed to read %v: %v", name, err)
+	}
+
+	return checkZip(m, tmp.Name())
+}
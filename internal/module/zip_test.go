@@ -0,0 +1,95 @@
+package module
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Create a module zip file holding small files with the given names
+func testZip(t *testing.T, m Module, names ...string) []byte {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, name := range names {
+		file, err := writer.Create(m.Path + "@" + m.Version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte("package x\n"))
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestIsVendoredPackage(t *testing.T) {
+	tests := []struct {
+		name     string
+		vendored bool
+	}{
+		{"vendor/modules.txt", false},
+		{"vendor/README", false},
+		{"vendor/golang.org/x/mod/go.mod", true},
+		{"sub/vendor/example.com/a/a.go", true},
+		{"sub/vendor/modules.txt", false},
+		{"vendored/a/a.go", false},
+		{"internal/vendorx/a/a.go", false},
+		{"a.go", false},
+	}
+
+	for _, test := range tests {
+		if vendored := isVendoredPackage(test.name); vendored != test.vendored {
+			t.Errorf("isVendoredPackage(%q) = %v, expected %v", test.name, vendored, test.vendored)
+		}
+	}
+}
+
+func TestCheckZip(t *testing.T) {
+	m := Module{Path: "example.com/a", Version: "v1.0.0"}
+	tests := []struct {
+		files []string
+		err   string
+	}{
+		{[]string{"go.mod", "a.go"}, ""},
+		{[]string{"go.mod", "a.go", "vendor/modules.txt"}, ""},
+		{[]string{"go.mod", "vendor/example.com/b/b.go"}, "vendor/example.com/b/b.go is a vendored package file"},
+		{[]string{"go.mod", "sub/vendor/example.com/b/b.go"}, "sub/vendor/example.com/b/b.go is a vendored package file"},
+		{[]string{"go.mod", "A.go", "a.go"}, "case-insensitive"},
+		{[]string{"go.mod", "../escape.go"}, "invalid"},
+	}
+
+	for _, test := range tests {
+		store := NewMemoryStorage()
+		writeTestFile(t, store, m.ZipFile().FilePath, string(testZip(t, m, test.files...)))
+
+		err := m.CheckZip(store)
+		if test.err == "" && err != nil {
+			t.Errorf("CheckZip(%v) = %v, expected no error", test.files, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("CheckZip(%v) = %v, expected an error containing %q", test.files, err, test.err)
+		}
+
+		if _, isZipError := err.(*ZipError); err != nil && !isZipError {
+			t.Errorf("CheckZip(%v) returned %T, expected *ZipError", test.files, err)
+		}
+	}
+}
+
+func TestCheckZipFileStorage(t *testing.T) {
+	m := Module{Path: "example.com/a", Version: "v1.0.0"}
+	store := NewFileStorage(t.TempDir())
+	writeTestFile(t, store, m.ZipFile().FilePath, string(testZip(t, m, "go.mod", "vendor/example.com/b/b.go")))
+
+	if _, isZipError := m.CheckZip(store).(*ZipError); !isZipError {
+		t.Errorf("CheckZip of a directory did not reject a vendored package")
+	}
+
+	missing := Module{Path: "example.com/missing", Version: "v1.0.0"}
+	if err := missing.CheckZip(store); err == nil {
+		t.Errorf("CheckZip of a missing zip file succeeded")
+	}
+}
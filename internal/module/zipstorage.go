@@ -0,0 +1,176 @@
+package module
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Compressed files up to this size are decompressed into memory, larger
+// files into a temporary file
+const maxZipMemoryFile = 1 << 20
+
+// Read-only storage in a zip archive
+//
+// The archive's central directory is indexed when it is opened. Files that
+// are stored without compression are read in place, compressed files are
+// decompressed when they are opened, into a temporary file that is removed
+// when it is closed unless they are small.
+type ZipStorage struct {
+	path  string
+	file  *os.File
+	index map[string]*zip.File
+	names []string
+}
+
+// Open a zip archive of module files
+func OpenZipBundle(archivePath string) (*ZipStorage, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to read zip archive %v: %v", archivePath, err)
+	}
+
+	s := &ZipStorage{
+		path:  archivePath,
+		file:  file,
+		index: make(map[string]*zip.File),
+	}
+
+	for _, entry := range reader.File {
+		if strings.HasSuffix(entry.Name, "/") {
+			continue
+		}
+
+		name := cleanName(entry.Name)
+		if _, exists := s.index[name]; !exists {
+			s.names = append(s.names, name)
+		}
+		s.index[name] = entry
+	}
+	sort.Strings(s.names)
+
+	return s, nil
+}
+
+// Close the archive
+func (s *ZipStorage) Close() error {
+	return s.file.Close()
+}
+
+func (s *ZipStorage) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+	if _, ok := s.index[name]; ok {
+		return s.stat(name), nil
+	}
+
+	if entries, err := listNames(s.names, name, s.stat); err == nil && len(entries) > 0 {
+		return storageFileInfo{name: path.Base(name), dir: true}, nil
+	}
+
+	return nil, notExist("stat", name)
+}
+
+func (s *ZipStorage) Open(name string) (StorageFile, error) {
+	name = cleanName(name)
+	entry, ok := s.index[name]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+
+	if entry.Method == zip.Store {
+		offset, err := entry.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+
+		return sectionFile{
+			SectionReader: io.NewSectionReader(s.file, offset, int64(entry.UncompressedSize64)),
+			info:          s.stat(name),
+		}, nil
+	}
+
+	contents, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer contents.Close()
+
+	if entry.UncompressedSize64 <= maxZipMemoryFile {
+		data, err := io.ReadAll(contents)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decompress %v: %v", name, err)
+		}
+
+		return sectionFile{
+			SectionReader: io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))),
+			info:          s.stat(name),
+		}, nil
+	}
+
+	tmp, err := os.CreateTemp("", "goff-zip-*")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary file for %v: %v", name, err)
+	}
+
+	// The zip reader fails if an entry is larger than its recorded size
+	size, err := io.Copy(tmp, contents)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("Failed to decompress %v: %v", name, err)
+	}
+
+	return tempFile{
+		sectionFile: sectionFile{
+			SectionReader: io.NewSectionReader(tmp, 0, size),
+			info:          s.stat(name),
+		},
+		file: tmp,
+	}, nil
+}
+
+func (s *ZipStorage) Create(name string) (StorageWriter, error) {
+	return nil, &os.PathError{Op: "create", Path: name, Err: ErrReadOnly}
+}
+
+func (s *ZipStorage) List(dir string) ([]os.FileInfo, error) {
+	return listNames(s.names, dir, s.stat)
+}
+
+func (s *ZipStorage) stat(name string) storageFileInfo {
+	entry := s.index[name]
+	return storageFileInfo{
+		name:    path.Base(name),
+		size:    int64(entry.UncompressedSize64),
+		modTime: entry.Modified,
+	}
+}
+
+// A decompressed file that is removed when it is closed
+type tempFile struct {
+	sectionFile
+	file *os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.file.Close()
+	os.Remove(f.file.Name())
+	return err
+}
@@ -0,0 +1,81 @@
+package module
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestZipStorageOpen(t *testing.T) {
+	files := map[string][]byte{
+		"example.com/a/v1.0.0.zip":  bytes.Repeat([]byte("stored "), 1000),
+		"example.com/a/v1.0.0.mod":  []byte("module example.com/a\n"),
+		"example.com/a/v1.0.0.info": bytes.Repeat([]byte("deflated "), 2*maxZipMemoryFile/9),
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "bundle.zip")
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := zip.NewWriter(archive)
+	for name, contents := range files {
+		method := zip.Deflate
+		if filepath.Ext(name) == ".zip" {
+			method = zip.Store
+		}
+
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write(contents)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive.Close()
+
+	store, err := OpenZipBundle(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for name, contents := range files {
+		file, err := store.Open(name)
+		if err != nil {
+			t.Fatalf("Open(%v) failed: %v", name, err)
+		}
+
+		data, err := io.ReadAll(file)
+		if err != nil || !bytes.Equal(data, contents) {
+			t.Errorf("Open(%v) read %v bytes, %v, expected %v bytes", name, len(data), err, len(contents))
+		}
+
+		part := make([]byte, 4)
+		if _, err := file.ReadAt(part, int64(len(contents)-4)); err != nil || !bytes.Equal(part, contents[len(contents)-4:]) {
+			t.Errorf("ReadAt of the end of %v = %q, %v", name, part, err)
+		}
+
+		if info, err := file.Stat(); err != nil || info.Size() != int64(len(contents)) {
+			t.Errorf("Stat(%v) = %v, %v, expected size %v", name, info, err, len(contents))
+		}
+
+		tmp, spilled := file.(tempFile)
+		if spilled != (len(contents) > maxZipMemoryFile) {
+			t.Errorf("Open(%v) returned %T for %v bytes", name, file, len(contents))
+		}
+
+		file.Close()
+		if spilled {
+			if _, err := os.Stat(tmp.file.Name()); !os.IsNotExist(err) {
+				t.Errorf("Temporary file of %v was not removed when it was closed: %v", name, err)
+			}
+		}
+	}
+}
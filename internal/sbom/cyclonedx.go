@@ -0,0 +1,119 @@
+package sbom
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+type cdxDocument struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Licenses   []cdxLicense  `json:"licenses,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxLicense struct {
+	License struct {
+		ID string `json:"id"`
+	} `json:"license"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+// Write the BOM as a CycloneDX 1.5 JSON document
+//
+// The checksum database hashes of each module are written as the
+// golang:h1:mod and golang:h1:zip properties.
+func (b *BOM) WriteCycloneDX(out io.Writer) error {
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + newUUID(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: b.Time.Format(time.RFC3339),
+			Tools: cdxTools{
+				Components: []cdxComponent{{Type: "application", Name: b.Tool, Version: b.ToolVersion}},
+			},
+			Component: cdxComponent{Type: "application", Name: b.Name},
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{},
+	}
+
+	for _, c := range b.Components {
+		component := cdxComponent{
+			Type:    "library",
+			BOMRef:  c.PURL(),
+			Name:    c.Path,
+			Version: c.Version,
+			PURL:    c.PURL(),
+		}
+
+		if c.ZipSHA256 != "" {
+			component.Hashes = append(component.Hashes, cdxHash{"SHA-256", c.ZipSHA256})
+		}
+
+		for _, id := range c.KnownLicenses() {
+			var l cdxLicense
+			l.License.ID = id
+			component.Licenses = append(component.Licenses, l)
+		}
+
+		for _, fileType := range []string{"mod", "zip"} {
+			if hash := c.Hashes[fileType]; hash != "" {
+				component.Properties = append(component.Properties, cdxProperty{"golang:h1:" + fileType, hash})
+			}
+		}
+
+		dependency := cdxDependency{Ref: c.PURL(), DependsOn: []string{}}
+		for _, d := range c.DependsOn {
+			dependency.DependsOn = append(dependency.DependsOn, d.PURL())
+		}
+
+		doc.Components = append(doc.Components, component)
+		doc.Dependencies = append(doc.Dependencies, dependency)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,260 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/haboustak/goff/internal/license"
+	"github.com/haboustak/goff/internal/module"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Document formats
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+// A software bill of materials for the modules of a module set
+type BOM struct {
+	Name        string
+	Time        time.Time
+	Tool        string
+	ToolVersion string
+	Components  []*Component
+}
+
+// A module version listed in a BOM
+type Component struct {
+	Path    string
+	Version string
+
+	// Checksum database hashes of the mod and zip files, keyed by file type
+	Hashes map[string]string
+
+	// Hex SHA-256 of the zip file
+	ZipSHA256 string
+
+	// SPDX IDs of the licenses found in the zip file
+	Licenses []string
+
+	// The components required by the module's go.mod file
+	DependsOn []*Component
+}
+
+// Describe the stored modules in a BOM
+//
+// Dependency edges follow the requirements of each module's go.mod file. A
+// requirement refers to the version that minimal version selection picks for
+// the module's own build list, using the go.mod files in the store, or the
+// lowest higher version when that one is not listed. Requirements on modules
+// that are not listed are left out.
+func Build(name string, store module.Storage, modules []module.Module) (*BOM, error) {
+	b := &BOM{
+		Name: name,
+		Time: time.Now().UTC(),
+	}
+
+	sort.Slice(modules, func(a, c int) bool {
+		if modules[a].Path != modules[c].Path {
+			return modules[a].Path < modules[c].Path
+		}
+		return semver.Compare(modules[a].Version, modules[c].Version) < 0
+	})
+
+	byPath := make(map[string][]*Component)
+	for _, m := range modules {
+		component, err := newComponent(store, m)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Components = append(b.Components, component)
+		byPath[m.Path] = append(byPath[m.Path], component)
+	}
+
+	graph := &requirementGraph{store: store, required: make(map[module.Module][]module.Module)}
+	for _, component := range b.Components {
+		m := module.Module{Path: component.Path, Version: component.Version}
+		requirements, err := graph.requirements(m)
+		if err != nil {
+			return nil, err
+		}
+
+		selected, err := graph.selectVersions(m)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range requirements {
+			// Versions are sorted, so the first one that is not lower is
+			// the selected version or the lowest higher version
+			for _, dependency := range byPath[r.Path] {
+				if semver.Compare(dependency.Version, selected[r.Path]) >= 0 {
+					component.DependsOn = append(component.DependsOn, dependency)
+					break
+				}
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// The requirements of the module versions whose go.mod files are stored
+type requirementGraph struct {
+	store    module.Storage
+	required map[module.Module][]module.Module
+}
+
+// Get the requirements of a module version's go.mod file, or nil when the
+// file is not stored
+func (g *requirementGraph) requirements(m module.Module) ([]module.Module, error) {
+	if requirements, ok := g.required[m]; ok {
+		return requirements, nil
+	}
+
+	var requirements []module.Module
+	modBytes, err := module.ReadFile(g.store, m.ModuleFile().FilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		modDetails, err := modfile.ParseLax(m.Path, modBytes, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse gomod file for %v: %v", m, err)
+		}
+
+		for _, r := range modDetails.Require {
+			requirements = append(requirements, module.New(r.Mod))
+		}
+	}
+
+	g.required[m] = requirements
+	return requirements, nil
+}
+
+// Select the version of each module path in the build list of a module
+//
+// Like minimal version selection, the highest version required by any
+// module version reachable from m is selected.
+func (g *requirementGraph) selectVersions(m module.Module) (map[string]string, error) {
+	selected := map[string]string{m.Path: m.Version}
+	visited := map[module.Module]bool{m: true}
+	queue := []module.Module{m}
+	for len(queue) > 0 {
+		requirements, err := g.requirements(queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+
+		for _, r := range requirements {
+			if semver.Compare(r.Version, selected[r.Path]) > 0 {
+				selected[r.Path] = r.Version
+			}
+
+			if !visited[r] {
+				visited[r] = true
+				queue = append(queue, r)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+func newComponent(store module.Storage, m module.Module) (*Component, error) {
+	hashes, err := m.Hashes(store)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := m.Licenses(store)
+	if err != nil {
+		return nil, err
+	}
+
+	component := &Component{
+		Path:     m.Path,
+		Version:  m.Version,
+		Hashes:   hashes,
+		Licenses: license.IDs(matches),
+	}
+
+	// Modules with only an .info and .mod file have no zip to hash or scan
+	if matches == nil {
+		component.Licenses = []string{license.NoAssertion}
+		return component, nil
+	}
+
+	zipFile, err := store.Open(m.ZipFile().FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer zipFile.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, zipFile); err != nil {
+		return nil, fmt.Errorf("Failed to hash %v: %v", m.ZipFile().FilePath, err)
+	}
+	component.ZipSHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	return component, nil
+}
+
+// Get the package URL of a component, such as
+// pkg:golang/golang.org/x/mod@v0.10.0
+func (c *Component) PURL() string {
+	var segments []string
+	for _, segment := range strings.Split(c.Path, "/") {
+		segments = append(segments, url.PathEscape(segment))
+	}
+
+	version := strings.ReplaceAll(url.PathEscape(c.Version), "+", "%2B")
+	return "pkg:golang/" + strings.Join(segments, "/") + "@" + version
+}
+
+// Get the licenses of a component that are SPDX license IDs, leaving out
+// NONE, NOASSERTION and the family IDs of GNU licenses
+func (c *Component) KnownLicenses() []string {
+	var known []string
+	for _, id := range c.Licenses {
+		if id != license.None && id != license.NoAssertion && len(license.Forms(id)) == 1 {
+			known = append(known, id)
+		}
+	}
+
+	return known
+}
+
+// Write the BOM in a document format
+func (b *BOM) Write(out io.Writer, format string) error {
+	switch format {
+	case FormatCycloneDX:
+		return b.WriteCycloneDX(out)
+	case FormatSPDX:
+		return b.WriteSPDX(out)
+	}
+
+	return fmt.Errorf("Unknown SBOM format %q", format)
+}
+
+// Create a random version 4 UUID
+func newUUID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
@@ -0,0 +1,130 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/haboustak/goff/internal/license"
+	"io"
+	"strings"
+	"time"
+)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	Comment          string            `json:"comment,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// Write the BOM as an SPDX 2.3 JSON document
+//
+// The licenses found in a module are its declared license, joined with AND
+// when there are several. The checksum database hashes of each module are
+// written to the package comment.
+func (b *BOM) WriteSPDX(out io.Writer) error {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              b.Name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/goff-" + newUUID(),
+		CreationInfo: spdxCreationInfo{
+			Created:  b.Time.Format(time.RFC3339),
+			Creators: []string{fmt.Sprintf("Tool: %v-%v", b.Tool, b.ToolVersion)},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+
+	ids := make(map[*Component]string)
+	for i, c := range b.Components {
+		ids[c] = fmt.Sprintf("SPDXRef-Package-%v", i+1)
+	}
+
+	for _, c := range b.Components {
+		pkg := spdxPackage{
+			SPDXID:           ids[c],
+			Name:             c.Path,
+			VersionInfo:      c.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  c.PURL(),
+			}},
+		}
+
+		if known := c.KnownLicenses(); len(known) > 0 && len(known) == len(c.Licenses) {
+			pkg.LicenseDeclared = strings.Join(known, " AND ")
+		} else if len(c.Licenses) == 1 && c.Licenses[0] == license.None {
+			pkg.LicenseDeclared = "NONE"
+		}
+
+		if c.ZipSHA256 != "" {
+			pkg.Checksums = append(pkg.Checksums, spdxChecksum{"SHA256", c.ZipSHA256})
+		}
+
+		var hashes []string
+		for _, fileType := range []string{"mod", "zip"} {
+			if hash := c.Hashes[fileType]; hash != "" {
+				hashes = append(hashes, fmt.Sprintf("%v %v", fileType, hash))
+			}
+		}
+		if len(hashes) > 0 {
+			pkg.Comment = "Go checksum database hashes: " + strings.Join(hashes, ", ")
+		}
+
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-DOCUMENT", "DESCRIBES", ids[c]})
+		for _, d := range c.DependsOn {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{ids[c], "DEPENDS_ON", ids[d]})
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Access log line formats
+const (
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+)
+
+// A log of every request, in the Apache combined log format or as JSON lines
+type AccessLog struct {
+	out    io.Writer
+	format string
+	sync.Mutex
+}
+
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	Remote    string  `json:"remote"`
+	User      string  `json:"user,omitempty"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Protocol  string  `json:"protocol"`
+	Status    int     `json:"status"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"duration"`
+	Referer   string  `json:"referer,omitempty"`
+	UserAgent string  `json:"user_agent,omitempty"`
+}
+
+// Create an access log that writes lines in format to out
+func NewAccessLog(out io.Writer, format string) (*AccessLog, error) {
+	if format != AccessLogCombined && format != AccessLogJSON {
+		return nil, fmt.Errorf("Unknown access log format %q", format)
+	}
+
+	return &AccessLog{out: out, format: format}, nil
+}
+
+// The context key of the user a request was authenticated as
+type userKey struct{}
+
+// Record the user a request was authenticated as, which is logged by the
+// access log
+//
+// Requests that are not authenticated are logged without a user.
+func SetUser(request *http.Request, user string) {
+	if authenticated, ok := request.Context().Value(userKey{}).(*string); ok {
+		*authenticated = user
+	}
+}
+
+// Log every request to handler once it completes
+func (a *AccessLog) Wrap(handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		user := ""
+		recorder := &ResponseRecorder{ResponseWriter: writer}
+		handler(recorder, request.WithContext(context.WithValue(request.Context(), userKey{}, &user)))
+		a.Write(request, user, recorder.Status(), recorder.Bytes, start)
+	}
+}
+
+// Write the access log line of a completed request made by user, who is
+// empty when the request was not authenticated
+func (a *AccessLog) Write(request *http.Request, user string, status int, bytes int64, start time.Time) {
+	remote, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		remote = request.RemoteAddr
+	}
+
+	var line []byte
+	if a.format == AccessLogJSON {
+		line, _ = json.Marshal(accessLogEntry{
+			Time:      start.UTC().Format(time.RFC3339),
+			Remote:    remote,
+			User:      user,
+			Method:    request.Method,
+			Path:      request.URL.RequestURI(),
+			Protocol:  request.Proto,
+			Status:    status,
+			Bytes:     bytes,
+			Duration:  time.Since(start).Seconds(),
+			Referer:   request.Referer(),
+			UserAgent: request.UserAgent(),
+		})
+		line = append(line, '\n')
+	} else {
+		size := "-"
+		if bytes > 0 {
+			size = fmt.Sprint(bytes)
+		}
+
+		line = []byte(fmt.Sprintf("%v - %v [%v] \"%v %v %v\" %v %v %q %q\n",
+			remote,
+			orDash(user),
+			start.Format("02/Jan/2006:15:04:05 -0700"),
+			request.Method,
+			request.URL.RequestURI(),
+			request.Proto,
+			status,
+			size,
+			orDash(request.Referer()),
+			orDash(request.UserAgent())))
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	a.out.Write(line)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
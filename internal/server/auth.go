@@ -0,0 +1,316 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/mod/module"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Users, tokens and access rules read from configuration files
+//
+// HtpasswdFile holds user:hash lines written by the htpasswd tool.
+// TokenFile holds user:hash lines, where hash is the hex SHA-256 of a bearer
+// token. ACLFile holds group, allow and upload lines:
+//
+//	group NAME USER...
+//	allow PREFIX GROUP...
+//	upload PREFIX GROUP...
+//
+// A request for a module is allowed when the user belongs to a group of the
+// allow line with the longest PREFIX that matches the module path. PREFIX is
+// a module path, which may end in /..., and the prefix and group * match
+// everything and every authenticated user. upload lines grant uploads the
+// same way, and reading a module does not allow uploading it. Without an
+// ACLFile, every authenticated user can read and upload every module.
+type Auth struct {
+	HtpasswdFile string
+	TokenFile    string
+	ACLFile      string
+
+	users    map[string]string
+	tokens   map[string]string
+	groups   map[string]map[string]bool
+	rules    []accessRule
+	uploads  []accessRule
+	verified map[[sha256.Size]byte]bool
+	sync.RWMutex
+}
+
+type accessRule struct {
+	prefix string
+	groups []string
+}
+
+// Load the users, tokens and access rules used to authorize requests
+//
+// Any of the files can be empty, but at least one of htpasswdFile and
+// tokenFile should be set for any user to be authenticated.
+func LoadAuth(htpasswdFile string, tokenFile string, aclFile string) (*Auth, error) {
+	a := &Auth{
+		HtpasswdFile: htpasswdFile,
+		TokenFile:    tokenFile,
+		ACLFile:      aclFile,
+	}
+
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Read the configuration files again
+//
+// The current configuration is kept if any file cannot be loaded.
+func (a *Auth) Reload() error {
+	users := make(map[string]string)
+	if a.HtpasswdFile != "" {
+		err := readLines(a.HtpasswdFile, func(line string) error {
+			user, hash, err := splitCredential(line)
+			users[user] = hash
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	tokens := make(map[string]string)
+	if a.TokenFile != "" {
+		err := readLines(a.TokenFile, func(line string) error {
+			user, hash, err := splitCredential(line)
+			tokens[strings.ToLower(hash)] = user
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	groups := make(map[string]map[string]bool)
+	var rules, uploads []accessRule
+	if a.ACLFile != "" {
+		err := readLines(a.ACLFile, func(line string) error {
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				return fmt.Errorf("expected \"group NAME USER...\", \"allow PREFIX GROUP...\" or \"upload PREFIX GROUP...\"")
+			}
+
+			switch fields[0] {
+			case "group":
+				if groups[fields[1]] == nil {
+					groups[fields[1]] = make(map[string]bool)
+				}
+				for _, user := range fields[2:] {
+					groups[fields[1]][user] = true
+				}
+			case "allow", "upload":
+				prefix, err := parsePathPrefix(fields[1])
+				if err != nil {
+					return err
+				}
+
+				rule := accessRule{prefix: prefix, groups: fields[2:]}
+				if fields[0] == "allow" {
+					rules = append(rules, rule)
+				} else {
+					uploads = append(uploads, rule)
+				}
+			default:
+				return fmt.Errorf("unknown directive %q", fields[0])
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	a.Lock()
+	a.users = users
+	a.tokens = tokens
+	a.groups = groups
+	a.rules = rules
+	a.uploads = uploads
+	a.verified = make(map[[sha256.Size]byte]bool)
+	a.Unlock()
+
+	return nil
+}
+
+// Get the user that sent a request
+//
+// Requests authenticate with a bearer token, or with basic auth using either
+// the user's password or one of the user's tokens, as the go command does
+// with credentials from .netrc.
+func (a *Auth) Authenticate(request *http.Request) (string, bool) {
+	header := request.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return a.checkToken(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	}
+
+	user, password, ok := request.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	if a.checkPassword(user, password) {
+		return user, true
+	}
+
+	if tokenUser, ok := a.checkToken(password); ok && tokenUser == user {
+		return user, true
+	}
+
+	return "", false
+}
+
+// Test if a user may read a module
+func (a *Auth) Allowed(user string, modPath string) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.ACLFile == "" || a.matches(a.rules, user, modPath)
+}
+
+// Test if a user may upload a module
+func (a *Auth) CanUpload(user string, modPath string) bool {
+	a.RLock()
+	defer a.RUnlock()
+
+	return a.ACLFile == "" || a.matches(a.uploads, user, modPath)
+}
+
+// Test if a user belongs to a group of the rule with the longest prefix that
+// matches a module path, the caller must hold the lock
+func (a *Auth) matches(rules []accessRule, user string, modPath string) bool {
+	var match *accessRule
+	for i, rule := range rules {
+		if !matchesPathPrefix(modPath, rule.prefix) {
+			continue
+		}
+
+		if match == nil || len(rule.prefix) > len(match.prefix) || match.prefix == "*" {
+			match = &rules[i]
+		}
+	}
+
+	if match == nil {
+		return false
+	}
+
+	for _, group := range match.groups {
+		if group == "*" || a.groups[group][user] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check a password against the htpasswd file, remembering passwords that
+// have already been verified because bcrypt is slow
+func (a *Auth) checkPassword(user string, password string) bool {
+	a.RLock()
+	hash, ok := a.users[user]
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + hash))
+	verified := a.verified[key]
+	a.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	if verified {
+		return true
+	}
+
+	if !checkPassword(hash, password) {
+		return false
+	}
+
+	a.Lock()
+	a.verified[key] = true
+	a.Unlock()
+
+	return true
+}
+
+// Find the user that owns a token
+func (a *Auth) checkToken(token string) (string, bool) {
+	sum := sha256.Sum256([]byte(token))
+
+	a.RLock()
+	defer a.RUnlock()
+
+	user, ok := a.tokens[hex.EncodeToString(sum[:])]
+	return user, ok
+}
+
+// Parse the PREFIX of an access rule
+//
+// The prefix is *, or a module path that may end in / or /... like the
+// patterns of the go command.
+func parsePathPrefix(field string) (string, error) {
+	if field == "*" {
+		return field, nil
+	}
+
+	prefix := strings.TrimSuffix(strings.TrimSuffix(field, "/..."), "/")
+	if err := module.CheckImportPath(prefix); err != nil {
+		return "", fmt.Errorf("invalid prefix %q: it must be * or a module path", field)
+	}
+
+	return prefix, nil
+}
+
+// Test if a module path is the same as prefix or is beneath it
+func matchesPathPrefix(modPath string, prefix string) bool {
+	if prefix == "*" || modPath == prefix {
+		return true
+	}
+
+	return strings.HasPrefix(modPath, prefix+"/")
+}
+
+// Split a user:hash line
+func splitCredential(line string) (string, string, error) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("expected user:hash")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// Call fn for every line of a file that is not blank or a comment
+func readLines(fileName string, fn func(line string) error) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := fn(line); err != nil {
+			return fmt.Errorf("%v:%v: %v", fileName, lineNumber, err)
+		}
+	}
+
+	return scanner.Err()
+}
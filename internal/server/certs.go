@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// A TLS certificate and optional client CA bundle that can be reloaded
+// while the server is running
+type Certificates struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	sync.RWMutex
+}
+
+// Load a certificate, its private key and an optional client CA bundle
+//
+// When clientCAFile is set, clients must present a certificate signed by
+// one of the CAs in the bundle.
+func LoadCertificates(certFile string, keyFile string, clientCAFile string) (*Certificates, error) {
+	c := &Certificates{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCAFile,
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Read the certificate files again
+//
+// The current certificates are kept if any file cannot be loaded.
+func (c *Certificates) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load certificate %v: %v", c.CertFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+	if c.ClientCAFile != "" {
+		caBytes, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read client CA bundle: %v", err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caBytes) {
+			return fmt.Errorf("No certificates found in client CA bundle %v", c.ClientCAFile)
+		}
+	}
+
+	c.Lock()
+	c.cert = &cert
+	c.clientCAs = clientCAs
+	c.Unlock()
+
+	return nil
+}
+
+// Get a TLS configuration that always uses the most recently loaded
+// certificates
+//
+// The configuration offers HTTP/2 and HTTP/1.1 with ALPN. The configuration
+// used for each connection is a copy of it with the current client CAs.
+func (c *Certificates) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			c.RLock()
+			defer c.RUnlock()
+
+			return c.cert, nil
+		},
+	}
+
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c.RLock()
+		defer c.RUnlock()
+
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		if c.clientCAs != nil {
+			config.ClientCAs = c.clientCAs
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+
+		return config, nil
+	}
+
+	return base
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// Check a password against a hash from an htpasswd file
+//
+// Supports the bcrypt ($2y$), Apache MD5 ($apr1$) and SHA-1 ({SHA}) formats
+// written by the htpasswd tool.
+func checkPassword(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(strings.TrimPrefix(hash, "$apr1$"), "$", 2)[0]
+		return constantTimeEqual(hash, apr1(password, salt))
+
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return constantTimeEqual(hash, "{SHA}"+base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	return false
+}
+
+func constantTimeEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Hash a password using Apache's variant of the MD5-based crypt algorithm
+func apr1(password string, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	alternate := md5.Sum([]byte(password + salt + password))
+
+	ctx := []byte(password + magic + salt)
+	for remaining := len(password); remaining > 0; remaining -= 16 {
+		n := remaining
+		if n > 16 {
+			n = 16
+		}
+		ctx = append(ctx, alternate[:n]...)
+	}
+
+	for i := len(password); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx = append(ctx, 0)
+		} else {
+			ctx = append(ctx, password[0])
+		}
+	}
+
+	final := md5.Sum(ctx)
+	for i := 0; i < 1000; i++ {
+		var round []byte
+		if i&1 != 0 {
+			round = append(round, password...)
+		} else {
+			round = append(round, final[:]...)
+		}
+
+		if i%3 != 0 {
+			round = append(round, salt...)
+		}
+
+		if i%7 != 0 {
+			round = append(round, password...)
+		}
+
+		if i&1 != 0 {
+			round = append(round, final[:]...)
+		} else {
+			round = append(round, password...)
+		}
+
+		final = md5.Sum(round)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	encoded := make([]byte, 0, 22)
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			encoded = append(encoded, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(final[group[0]])<<16|uint(final[group[1]])<<8|uint(final[group[2]]), 4)
+	}
+	encode(uint(final[11]), 2)
+
+	return magic + salt + "$" + string(encoded)
+}
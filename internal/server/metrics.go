@@ -0,0 +1,242 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the request duration histogram buckets, in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Request and cache metrics written in the Prometheus text format
+type Metrics struct {
+	requests  map[requestKey]uint64
+	bytes     map[string]uint64
+	durations map[string]*histogram
+	cache     map[string]uint64
+	gauges    []gauge
+	sync.Mutex
+}
+
+type requestKey struct {
+	endpoint string
+	code     int
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type gauge struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+// Create an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:  make(map[requestKey]uint64),
+		bytes:     make(map[string]uint64),
+		durations: make(map[string]*histogram),
+		cache:     make(map[string]uint64),
+	}
+}
+
+// Record a completed request
+func (m *Metrics) ObserveRequest(endpoint string, code int, bytes int64, duration time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.requests[requestKey{endpoint, code}]++
+	m.bytes[endpoint] += uint64(bytes)
+
+	h, ok := m.durations[endpoint]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[endpoint] = h
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Record whether a file was already stored or had to be fetched
+func (m *Metrics) ObserveCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	m.Lock()
+	m.cache[result]++
+	m.Unlock()
+}
+
+// Add a gauge whose value is read each time the metrics are written
+func (m *Metrics) Gauge(name string, help string, value func() float64) {
+	m.Lock()
+	m.gauges = append(m.gauges, gauge{name: name, help: help, value: value})
+	m.Unlock()
+}
+
+// Record the status, size and duration of every request to handler
+//
+// endpoint names the type of each request, such as "list" or "zip".
+func (m *Metrics) Instrument(handler http.HandlerFunc, endpoint func(*http.Request) string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &ResponseRecorder{ResponseWriter: writer}
+		handler(recorder, request)
+		m.ObserveRequest(endpoint(request), recorder.Status(), recorder.Bytes, time.Since(start))
+	}
+}
+
+// Serve the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(writer)
+}
+
+// Write the metrics in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	// Gauges can be slow, so they are read before taking the lock
+	m.Lock()
+	gauges := make([]gauge, len(m.gauges))
+	copy(gauges, m.gauges)
+	m.Unlock()
+
+	values := make([]float64, len(gauges))
+	for i, g := range gauges {
+		values[i] = g.value()
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	var out strings.Builder
+
+	writeHeader(&out, "goff_http_requests_total", "counter", "HTTP requests by endpoint and status code")
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].endpoint != keys[b].endpoint {
+			return keys[a].endpoint < keys[b].endpoint
+		}
+		return keys[a].code < keys[b].code
+	})
+	for _, key := range keys {
+		fmt.Fprintf(&out, "goff_http_requests_total{endpoint=%v,code=\"%v\"} %v\n",
+			quoteLabel(key.endpoint), key.code, m.requests[key])
+	}
+
+	writeHeader(&out, "goff_http_response_bytes_total", "counter", "Bytes written in HTTP responses by endpoint")
+	for _, endpoint := range sortedKeys(m.bytes) {
+		fmt.Fprintf(&out, "goff_http_response_bytes_total{endpoint=%v} %v\n", quoteLabel(endpoint), m.bytes[endpoint])
+	}
+
+	writeHeader(&out, "goff_http_request_duration_seconds", "histogram", "HTTP request latency by endpoint")
+	endpoints := make([]string, 0, len(m.durations))
+	for endpoint := range m.durations {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		h := m.durations[endpoint]
+		label := quoteLabel(endpoint)
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(&out, "goff_http_request_duration_seconds_bucket{endpoint=%v,le=\"%v\"} %v\n",
+				label, formatFloat(bound), h.counts[i])
+		}
+		fmt.Fprintf(&out, "goff_http_request_duration_seconds_bucket{endpoint=%v,le=\"+Inf\"} %v\n", label, h.count)
+		fmt.Fprintf(&out, "goff_http_request_duration_seconds_sum{endpoint=%v} %v\n", label, formatFloat(h.sum))
+		fmt.Fprintf(&out, "goff_http_request_duration_seconds_count{endpoint=%v} %v\n", label, h.count)
+	}
+
+	writeHeader(&out, "goff_cache_requests_total", "counter", "Module files served from storage (hit) or fetched upstream (miss)")
+	for _, result := range sortedKeys(m.cache) {
+		fmt.Fprintf(&out, "goff_cache_requests_total{result=%v} %v\n", quoteLabel(result), m.cache[result])
+	}
+
+	for i, g := range gauges {
+		writeHeader(&out, g.name, "gauge", g.help)
+		fmt.Fprintf(&out, "%v %v\n", g.name, formatFloat(values[i]))
+	}
+
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+func writeHeader(out *strings.Builder, name string, metricType string, help string) {
+	fmt.Fprintf(out, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, metricType)
+}
+
+func sortedKeys(values map[string]uint64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Quote a label value, escaping backslashes, quotes and newlines
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// An http.ResponseWriter that remembers the status code and the number of
+// bytes written
+type ResponseRecorder struct {
+	http.ResponseWriter
+	StatusCode int
+	Bytes      int64
+}
+
+func (r *ResponseRecorder) WriteHeader(code int) {
+	if r.StatusCode == 0 {
+		r.StatusCode = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *ResponseRecorder) Write(data []byte) (int, error) {
+	if r.StatusCode == 0 {
+		r.StatusCode = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(data)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Get the status code of the response, which is 200 if nothing was written
+func (r *ResponseRecorder) Status() int {
+	if r.StatusCode == 0 {
+		return http.StatusOK
+	}
+	return r.StatusCode
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"os"
+	"os/signal"
+)
+
+// Configuration that is read from files and can be read again while the
+// server is running
+type Reloader interface {
+	Reload() error
+}
+
+// Reload r whenever one of the signals is received
+//
+// Errors are passed to onError and the previous configuration stays in use.
+func ReloadOnSignal(r Reloader, onError func(error), signals ...os.Signal) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+
+	go func() {
+		for range received {
+			if err := r.Reload(); err != nil {
+				onError(err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,231 @@
+package vulndb
+
+import (
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+	"golang.org/x/mod/semver"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A vulnerability entry in the OSV format
+type Entry struct {
+	ID        string     `json:"id"`
+	Modified  time.Time  `json:"modified"`
+	Published time.Time  `json:"published"`
+	Withdrawn *time.Time `json:"withdrawn,omitempty"`
+	Aliases   []string   `json:"aliases,omitempty"`
+	Summary   string     `json:"summary,omitempty"`
+	Details   string     `json:"details"`
+	Affected  []Affected `json:"affected"`
+}
+
+// A module affected by a vulnerability
+type Affected struct {
+	Module struct {
+		Path      string `json:"name"`
+		Ecosystem string `json:"ecosystem"`
+	} `json:"package"`
+	Ranges []Range `json:"ranges,omitempty"`
+}
+
+// The versions of a module affected by a vulnerability
+//
+// Events are ordered. A version is affected from an introduced event until
+// the next fixed event.
+type Range struct {
+	Type   string       `json:"type"`
+	Events []RangeEvent `json:"events"`
+}
+
+type RangeEvent struct {
+	Introduced string `json:"introduced,omitempty"`
+	Fixed      string `json:"fixed,omitempty"`
+}
+
+// A module version affected by a vulnerability
+type Finding struct {
+	Module  module.Module
+	ID      string
+	Aliases []string
+	Summary string
+
+	// The lowest version that fixes the vulnerability, or "" if there is
+	// no fix. Fixes for toolchain modules are Go versions such as go1.21.5.
+	Fixed string
+}
+
+// Read a vulnerability entry from the database in a Storage
+func ReadEntry(store module.Storage, id string) (*Entry, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("Invalid vulnerability ID %q", id)
+	}
+
+	entry := new(Entry)
+	if err := readJSON(store, EntryFile(id), entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Test if a module version is affected by a vulnerability
+//
+// Returns the version that fixes the vulnerability for the module version,
+// or "" if it is not fixed.
+func (e *Entry) Affects(m module.Module) (bool, string) {
+	for _, affected := range e.Affected {
+		if affected.Module.Path != m.Path {
+			continue
+		}
+
+		// An entry without ranges affects every version
+		if len(affected.Ranges) == 0 {
+			return true, ""
+		}
+
+		for _, r := range affected.Ranges {
+			if r.Type != "SEMVER" {
+				continue
+			}
+
+			if isAffected, fixed := r.affects(m.Version); isAffected {
+				return true, fixed
+			}
+		}
+	}
+
+	return false, ""
+}
+
+func (r Range) affects(version string) (bool, string) {
+	affected := false
+	for _, event := range r.Events {
+		if event.Introduced != "" {
+			if event.Introduced == "0" || semver.Compare(version, osvVersion(event.Introduced)) >= 0 {
+				affected = true
+			}
+		} else if event.Fixed != "" {
+			if affected && semver.Compare(version, osvVersion(event.Fixed)) < 0 {
+				return true, osvVersion(event.Fixed)
+			}
+			affected = false
+		}
+	}
+
+	return affected, ""
+}
+
+// OSV versions of Go modules are written without the v prefix
+func osvVersion(version string) string {
+	return "v" + strings.TrimPrefix(version, "v")
+}
+
+// The database lists vulnerabilities in the standard library and the go
+// command under these module paths
+const (
+	stdlibPath    = "stdlib"
+	toolchainPath = "toolchain"
+)
+
+// Get the modules a module version is listed under in the database
+//
+// Toolchain modules are listed as the stdlib and toolchain modules at the
+// semantic version of their Go version, such as v1.21.0-rc.1 for go1.21rc1.
+func lookupModules(m module.Module) []module.Module {
+	if m.Path != module.ToolchainPath {
+		return []module.Module{m}
+	}
+
+	goVersion := module.ToolchainGoVersion(m.Version)
+	pre := ""
+	for _, kind := range []string{"alpha", "beta", "rc"} {
+		if i := strings.Index(goVersion, kind); i > 0 {
+			goVersion, pre = goVersion[:i], "-"+kind+"."+goVersion[i+len(kind):]
+			break
+		}
+	}
+
+	if strings.Count(goVersion, ".") == 1 {
+		goVersion += ".0"
+	}
+
+	version := "v" + goVersion + pre
+	if !semver.IsValid(version) {
+		return nil
+	}
+
+	return []module.Module{
+		{Path: stdlibPath, Version: version},
+		{Path: toolchainPath, Version: version},
+	}
+}
+
+// Find the vulnerabilities that affect a list of module versions
+//
+// Only the entries the modules index lists for each module path are read.
+// Toolchain modules are checked against the stdlib and toolchain entries.
+// Withdrawn entries are ignored. Findings are sorted by module and ID.
+func Scan(store module.Storage, modules []module.Module) ([]Finding, error) {
+	index, err := ReadModulesIndex(store)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %v: %v", path.Join(Dir, ModulesIndexFile), err)
+	}
+
+	byPath := make(map[string][]ModuleVuln)
+	for _, entry := range index {
+		byPath[entry.Path] = entry.Vulns
+	}
+
+	entries := make(map[string]*Entry)
+	var findings []Finding
+	for _, m := range modules {
+		found := make(map[string]bool)
+		for _, lookup := range lookupModules(m) {
+			for _, v := range byPath[lookup.Path] {
+				entry, read := entries[v.ID]
+				if !read {
+					if entry, err = ReadEntry(store, v.ID); err != nil {
+						return nil, fmt.Errorf("Failed to read %v: %v", v.ID, err)
+					}
+					entries[v.ID] = entry
+				}
+
+				if entry.Withdrawn != nil || found[entry.ID] {
+					continue
+				}
+
+				if affected, fixed := entry.Affects(lookup); affected {
+					// Fixes of the stdlib and toolchain are Go releases
+					if lookup.Path != m.Path && fixed != "" {
+						fixed = "go" + strings.TrimPrefix(fixed, "v")
+					}
+
+					found[entry.ID] = true
+					findings = append(findings, Finding{
+						Module:  m,
+						ID:      entry.ID,
+						Aliases: entry.Aliases,
+						Summary: entry.Summary,
+						Fixed:   fixed,
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(findings, func(a, b int) bool {
+		ma, mb := findings[a].Module, findings[b].Module
+		if ma.Path != mb.Path {
+			return ma.Path < mb.Path
+		}
+		if ma.Version != mb.Version {
+			return semver.Compare(ma.Version, mb.Version) < 0
+		}
+		return findings[a].ID < findings[b].ID
+	})
+
+	return findings, nil
+}
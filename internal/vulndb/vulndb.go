@@ -0,0 +1,201 @@
+package vulndb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// The directory of a Storage that holds the vulnerability database
+//
+// Module paths must have a dot in their first element, so the directory
+// cannot be mistaken for a module.
+const Dir = "vulndb"
+
+// Files of the database, relative to its root, in the layout served by
+// vuln.go.dev
+const (
+	DBIndexFile      = "index/db.json"
+	ModulesIndexFile = "index/modules.json"
+	VulnsIndexFile   = "index/vulns.json"
+)
+
+// The metadata of the database
+type DBIndex struct {
+	Modified time.Time `json:"modified"`
+}
+
+// A module and the vulnerabilities that affect it
+type ModuleEntry struct {
+	Path  string       `json:"path"`
+	Vulns []ModuleVuln `json:"vulns,omitempty"`
+}
+
+// A vulnerability that affects a module
+type ModuleVuln struct {
+	ID       string    `json:"id"`
+	Modified time.Time `json:"modified"`
+
+	// The highest version that fixes the vulnerability
+	Fixed string `json:"fixed,omitempty"`
+}
+
+// A vulnerability listed by the vulns index
+type VulnEntry struct {
+	ID       string    `json:"id"`
+	Modified time.Time `json:"modified"`
+	Aliases  []string  `json:"aliases,omitempty"`
+}
+
+// IDs of the Go vulnerability database, such as GO-2022-0001
+var validID = regexp.MustCompile(`^GO-\d{4}-\d{4,}$`)
+
+// Get the name of a vulnerability's file relative to the database root
+func EntryFile(id string) string {
+	return path.Join("ID", id+".json")
+}
+
+// Copy the vulnerability database at dbUrl to the Dir directory of a Storage
+//
+// Entries that have not been modified since the last snapshot are not
+// downloaded again. The indexes are written last, so an interrupted snapshot
+// leaves the previous indexes in place. Returns the number of entries that
+// were downloaded.
+func Snapshot(dbUrl *url.URL, store module.Storage) (int, error) {
+	dbIndex, err := fetch(dbUrl, DBIndexFile)
+	if err != nil {
+		return 0, err
+	}
+
+	modulesIndex, err := fetch(dbUrl, ModulesIndexFile)
+	if err != nil {
+		return 0, err
+	}
+
+	vulnsIndex, err := fetch(dbUrl, VulnsIndexFile)
+	if err != nil {
+		return 0, err
+	}
+
+	var vulns []VulnEntry
+	if err := json.Unmarshal(vulnsIndex, &vulns); err != nil {
+		return 0, fmt.Errorf("Invalid %v: %v", VulnsIndexFile, err)
+	}
+
+	stored := make(map[string]time.Time)
+	if previous, err := ReadVulnsIndex(store); err == nil {
+		for _, v := range previous {
+			stored[v.ID] = v.Modified
+		}
+	}
+
+	downloaded := 0
+	for _, v := range vulns {
+		if !validID.MatchString(v.ID) {
+			return downloaded, fmt.Errorf("Invalid vulnerability ID %q in %v", v.ID, VulnsIndexFile)
+		}
+
+		entryFile := EntryFile(v.ID)
+		if modified, ok := stored[v.ID]; ok && modified.Equal(v.Modified) {
+			if _, err := store.Stat(path.Join(Dir, entryFile)); err == nil {
+				continue
+			}
+		}
+
+		entry, err := fetch(dbUrl, entryFile)
+		if err != nil {
+			return downloaded, err
+		}
+
+		if err := module.WriteFile(store, path.Join(Dir, entryFile), entry); err != nil {
+			return downloaded, fmt.Errorf("Failed to store %v: %v", entryFile, err)
+		}
+		downloaded++
+	}
+
+	for _, index := range []struct {
+		name string
+		data []byte
+	}{
+		{VulnsIndexFile, vulnsIndex},
+		{ModulesIndexFile, modulesIndex},
+		{DBIndexFile, dbIndex},
+	} {
+		if err := module.WriteFile(store, path.Join(Dir, index.name), index.data); err != nil {
+			return downloaded, fmt.Errorf("Failed to store %v: %v", index.name, err)
+		}
+	}
+
+	return downloaded, nil
+}
+
+// Read the vulns index of the database in a Storage
+func ReadVulnsIndex(store module.Storage) ([]VulnEntry, error) {
+	var vulns []VulnEntry
+	err := readJSON(store, VulnsIndexFile, &vulns)
+	return vulns, err
+}
+
+// Read the modules index of the database in a Storage
+func ReadModulesIndex(store module.Storage) ([]ModuleEntry, error) {
+	var modules []ModuleEntry
+	err := readJSON(store, ModulesIndexFile, &modules)
+	return modules, err
+}
+
+// Read the metadata of the database in a Storage
+func ReadDBIndex(store module.Storage) (*DBIndex, error) {
+	index := new(DBIndex)
+	err := readJSON(store, DBIndexFile, index)
+	return index, err
+}
+
+func readJSON(store module.Storage, name string, value interface{}) error {
+	data, err := module.ReadFile(store, path.Join(Dir, name))
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("Invalid %v: %v", name, err)
+	}
+
+	return nil
+}
+
+// Download a file of the database and check that it is valid JSON
+func fetch(dbUrl *url.URL, name string) ([]byte, error) {
+	fileUrl, err := dbUrl.Parse(path.Join(dbUrl.Path, name))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := module.HttpGet(fileUrl)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download %v: %v", fileUrl, err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to download %v: %v", fileUrl, err)
+	}
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("Invalid JSON in %v", fileUrl)
+	}
+
+	return data, nil
+}
+
+// Test if a Storage holds a vulnerability database
+func Exists(store module.Storage) bool {
+	_, err := store.Stat(path.Join(Dir, DBIndexFile))
+	return !os.IsNotExist(err)
+}
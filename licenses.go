@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/haboustak/goff/internal/license"
+	"github.com/haboustak/goff/internal/module"
+	"strings"
+)
+
+var licensesJSON bool
+
+var cmdLicenses = &Command{
+	Name: "licenses",
+	Run:  licenses,
+	Usage: `Usage:
+    goff [-h] licenses [-json] module_dir
+
+Report the licenses of the modules in a module set
+
+module_dir is a directory, a .tar or .zip bundle of module files, or an
+S3-compatible bucket. The license files in the root of each module's zip
+file, such as LICENSE, LICENSE.md and COPYING, are classified to SPDX
+license IDs using the license texts bundled with goff. NONE means the module
+has no license files, and NOASSERTION means a license file did not match
+any known license. GNU licenses are reported by their family, like GPL-3.0,
+because the license text does not show whether a module is licensed under
+the -only or -or-later terms.
+
+The licenses of downloaded and uploaded modules are also recorded in their
+manifests, and can be allowed or denied by a -policy file, see "goff help
+download".
+
+Options:
+    -h      show this help
+    -json   print each module and its license files as a JSON line
+`,
+}
+
+type moduleLicenses struct {
+	Path     string
+	Version  string
+	Licenses []license.Match
+}
+
+func init() {
+	cmdLicenses.Flags.BoolVar(&licensesJSON, "json", false, "print modules as JSON lines")
+}
+
+func licenses(self *Command) error {
+	args := self.Flags.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("You must specify the module set directory")
+	}
+
+	store, err := module.OpenStorage(args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to open module set %v: %v", args[0], err)
+	}
+
+	modules, err := module.StoredModules(store)
+	if err != nil {
+		return fmt.Errorf("Failed to read module set %v: %v", args[0], err)
+	}
+
+	for _, m := range modules {
+		matches, err := m.Licenses(store)
+		if err != nil {
+			return err
+		}
+
+		if licensesJSON {
+			line, _ := json.Marshal(moduleLicenses{m.Path, m.Version, matches})
+			fmt.Println(string(line))
+			continue
+		}
+
+		var files []string
+		for _, match := range matches {
+			if match.File != "" {
+				files = append(files, fmt.Sprintf("%v=%v", match.File, match.ID))
+			}
+		}
+		fmt.Printf("%v %v %v\n", m, strings.Join(license.IDs(matches), ","), strings.Join(files, " "))
+	}
+
+	return nil
+}
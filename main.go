@@ -3,12 +3,16 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/haboustak/goff/internal/logging"
 	"os"
 )
 
 var BasePath string
 var Version = "v0.9.1"
 
+// The logger used by every command
+var logger = logging.Default()
+
 type Command struct {
 	Name  string
 	Run   func(cmd *Command) error
@@ -20,14 +24,24 @@ var commands = []*Command{
 	cmdServe,
 	cmdDownload,
 	cmdUpload,
+	cmdApprove,
+	cmdReject,
+	cmdAudit,
+	cmdAuditVulns,
+	cmdLicenses,
+	cmdSbom,
 }
 
 func main() {
 	var showHelp bool
 	var version bool
+	var logFormat string
+	var logLevel string
 
 	flag.BoolVar(&showHelp, "h", false, "show help")
 	flag.BoolVar(&version, "v", false, "print version information")
+	flag.StringVar(&logFormat, "log-format", "text", "log format, text or json")
+	flag.StringVar(&logLevel, "log-level", "info", "minimum log level")
 
 	flag.Usage = func() {
 		printUsage(defaultUsage)
@@ -41,6 +55,11 @@ func main() {
 		printVersion()
 	}
 
+	if err := configureLogger(logFormat, logLevel); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	var command string
 	if len(args) > 0 {
 		command = args[0]
@@ -63,7 +82,7 @@ func main() {
 		cmd.Flags.Parse(args)
 		err := cmd.Run(cmd)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
+			logger.Error(err.Error())
 			os.Exit(1)
 		}
 		cmdFound = true
@@ -83,18 +102,43 @@ func main() {
 var defaultUsage = `goff is a tool for managing an offline Go proxy
 
 Usage:
-    goff [-h] [-v] COMMAND [arguments]
+    goff [-h] [-v] [-log-format text|json] [-log-level LEVEL] COMMAND [arguments]
 
 Options:
    -h           show this help
+   -log-format  write log messages as text or json lines (default=text)
+   -log-level   write log messages at debug, info, warn or error level and
+                above (default=info). Security errors are always written.
    -v           print version information
 
 Commands:
     serve       Start the proxy HTTP service
     download    Download a module and into a module set
     upload      Upload a module set to the proxy
+    approve     Move quarantined modules into the proxy
+    reject      Delete quarantined modules
+    audit       Query the audit log of collected and uploaded modules
+    audit-vulns Report the modules in a module set with known vulnerabilities
+    licenses    Report the licenses of the modules in a module set
+    sbom        Write a software bill of materials for a module set
 `
 
+// Replace the default logger with one using the requested format and level
+func configureLogger(formatName string, levelName string) error {
+	format, err := logging.ParseFormat(formatName)
+	if err != nil {
+		return err
+	}
+
+	level, err := logging.ParseLevel(levelName)
+	if err != nil {
+		return err
+	}
+
+	logger = logging.New(os.Stdout, os.Stderr, format, level)
+	return nil
+}
+
 func printUsage(usage string) {
 	fmt.Fprintf(os.Stderr, usage)
 	os.Exit(1)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/haboustak/goff/internal/license"
+	"github.com/haboustak/goff/internal/module"
+	"os"
+	"sort"
+)
+
+var policyFile string
+
+// Add the flag that sets the module policy file to a command
+func addPolicyFlag(flags *flag.FlagSet) {
+	flags.StringVar(&policyFile, "policy", os.Getenv("GOFF_POLICY"), "file of module allow and deny rules")
+}
+
+// Read the module policy, or return nil if it is not configured
+func loadPolicy() (*module.Policy, error) {
+	if policyFile == "" {
+		return nil, nil
+	}
+
+	policy, err := module.LoadPolicy(policyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load policy: %v", err)
+	}
+
+	logger.Debug("Loaded policy", "policy", policyFile, "rules", len(policy.Rules))
+	return policy, nil
+}
+
+// Check the paths, versions and licenses of stored modules against the
+// policy and return every violation
+//
+// Licenses are only scanned when the policy has license rules.
+func checkPolicy(policy *module.Policy, store module.Storage, modules []module.Module) ([]error, error) {
+	var violations []error
+	for _, m := range modules {
+		err := policy.Check(m)
+		if err == nil && policy.HasLicenseRules() {
+			matches, scanErr := m.Licenses(store)
+			if scanErr != nil {
+				return nil, scanErr
+			}
+			err = policy.CheckLicenses(m, license.IDs(matches))
+		}
+
+		if err != nil {
+			violations = append(violations, err)
+		}
+	}
+
+	return violations, nil
+}
+
+// Log each policy violation and return an error if there are any
+func reportViolations(violations []error) error {
+	sort.Slice(violations, func(a, b int) bool {
+		return violations[a].Error() < violations[b].Error()
+	})
+
+	for _, violation := range violations {
+		logger.Error("Policy violation", "error", violation)
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%v module versions are denied by policy %v", len(violations), policyFile)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	quarantineDir      string
+	quarantineManifest string
+)
+
+// Add the flag that sets the quarantine root to a command
+func addQuarantineFlag(flags *flag.FlagSet, usage string) {
+	flags.StringVar(&quarantineDir, "quarantine", os.Getenv("GOFF_QUARANTINE"), usage)
+}
+
+// Open the storage that holds modules until they are approved
+//
+// Approved and rejected modules are removed from the quarantine, so it must
+// be a directory or a bucket rather than a bundle.
+func openQuarantine() (module.Storage, error) {
+	if module.IsBundle(quarantineDir) {
+		return nil, fmt.Errorf("The quarantine %v cannot be a bundle", quarantineDir)
+	}
+
+	store, err := module.OpenStorage(quarantineDir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open quarantine %v: %v", quarantineDir, err)
+	}
+
+	if _, canRemove := store.(module.Remover); !canRemove {
+		return nil, fmt.Errorf("The quarantine %v must be a directory or a bucket", quarantineDir)
+	}
+
+	return store, nil
+}
+
+// Check that the quarantine is not a root and is not inside one, so that
+// quarantined modules cannot be served
+func checkQuarantineDir(rootDirs []string) error {
+	quarantine, err := storagePath(quarantineDir)
+	if err != nil {
+		return err
+	}
+
+	for _, rootDir := range rootDirs {
+		root, err := storagePath(rootDir)
+		if err != nil {
+			return err
+		}
+
+		if quarantine == root {
+			return fmt.Errorf("The quarantine %v cannot be the root %v", quarantineDir, rootDir)
+		}
+
+		if strings.HasPrefix(quarantine, root+"/") {
+			return fmt.Errorf("The quarantine %v cannot be inside the root %v", quarantineDir, rootDir)
+		}
+
+		if strings.HasPrefix(root, quarantine+"/") {
+			return fmt.Errorf("The root %v cannot be inside the quarantine %v", rootDir, quarantineDir)
+		}
+	}
+
+	return nil
+}
+
+// Get a comparable form of a storage spec: the absolute path of a
+// directory or bundle, or the bucket and prefix of an S3 url
+func storagePath(spec string) (string, error) {
+	if strings.HasPrefix(spec, "s3://") {
+		return strings.TrimSuffix(strings.SplitN(spec, "?", 2)[0], "/"), nil
+	}
+
+	absPath, err := filepath.Abs(spec)
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the absolute path of %v: %v", spec, err)
+	}
+
+	return filepath.ToSlash(filepath.Clean(absPath)), nil
+}
+
+// Test if a module version is served from a root
+func isStored(store module.Storage, m module.Module) bool {
+	_, err := store.Stat(m.InfoFile().FilePath)
+	return err == nil
+}
+
+// Select the quarantined modules named by the arguments or listed by the
+// -manifest of their upload, or every quarantined module
+//
+// Modules of the manifest that are no longer quarantined, because they have
+// been approved or rejected, are skipped. Named modules must be quarantined.
+func selectQuarantined(q module.Storage, names []string) ([]module.Module, error) {
+	var modules []module.Module
+	if quarantineManifest != "" {
+		manifest, err := module.LoadManifest(q, quarantineManifest)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read manifest %v: %v", quarantineManifest, err)
+		}
+
+		for _, listed := range manifest.Modules {
+			m := module.Module{Path: listed.Path, Version: listed.Version}
+			if !isStored(q, m) {
+				logger.Warn("Module is not in quarantine", "module", m, "manifest", manifest.ID)
+				continue
+			}
+			modules = append(modules, m)
+		}
+	}
+
+	for _, name := range names {
+		m, err := module.Parse(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Version == "" {
+			return nil, fmt.Errorf("You must name the version of %v", m.Path)
+		}
+
+		if !isStored(q, m) {
+			return nil, fmt.Errorf("%v is not in quarantine %v", m, quarantineDir)
+		}
+		modules = append(modules, m)
+	}
+
+	if quarantineManifest == "" && len(names) == 0 {
+		var err error
+		if modules, err = module.StoredModules(q); err != nil {
+			return nil, fmt.Errorf("Failed to read quarantine %v: %v", quarantineDir, err)
+		}
+	}
+
+	if len(modules) == 0 {
+		return nil, fmt.Errorf("No quarantined modules were selected in %v", quarantineDir)
+	}
+
+	return modules, nil
+}
+
+// Delete the files of a module from the quarantine
+//
+// The .info file is removed first, so a module that is only partly removed
+// is no longer listed.
+func removeQuarantined(q module.Storage, m module.Module) error {
+	remover := q.(module.Remover)
+	for _, file := range []module.ModuleFile{m.InfoFile(), m.ModuleFile(), m.ZipFile()} {
+		if err := remover.Remove(file.FilePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove %v from quarantine: %v", file.FilePath, err)
+		}
+	}
+
+	return nil
+}
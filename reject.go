@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+)
+
+var rejectReason string
+
+var cmdReject = &Command{
+	Name: "reject",
+	Run:  reject,
+	Usage: `Usage:
+    goff [-h] reject -quarantine QUARANTINE [-manifest id] [-reason text]
+                     [-audit-log file -audit-key file] [-operator name]
+                     [modules]
+
+Delete quarantined modules
+
+The modules named path@version or the modules listed by the -manifest of
+their upload are deleted from QUARANTINE. One of them must be given.
+
+Rejected modules are listed with their hashes and the -reason in a reject
+manifest in QUARANTINE, and are added to the audit log.
+
+Options:
+    -audit-log  append rejected modules to an audit log
+                (default=GOFF_AUDIT_LOG)
+    -audit-key  key used to sign audit records (default=GOFF_AUDIT_KEY)
+    -h          show this help
+    -manifest   reject the quarantined modules of an upload manifest
+    -operator   name recorded in the audit log (default=GOFF_OPERATOR or the
+                current user)
+    -quarantine storage that holds modules until they are approved
+                (default=GOFF_QUARANTINE)
+    -reason     reason recorded in the reject manifest
+`,
+}
+
+func init() {
+	cmdReject.Flags.StringVar(&quarantineManifest, "manifest", "", "manifest ID of the modules to reject")
+	cmdReject.Flags.StringVar(&rejectReason, "reason", "", "reason the modules are rejected")
+	addQuarantineFlag(&cmdReject.Flags, "storage that holds modules until they are approved")
+	addAuditFlags(&cmdReject.Flags)
+}
+
+func reject(self *Command) (err error) {
+	if quarantineDir == "" {
+		return fmt.Errorf("You must specify the quarantine with -quarantine")
+	}
+
+	// Rejecting every quarantined module by accident would lose uploads
+	if quarantineManifest == "" && len(self.Flags.Args()) == 0 {
+		return fmt.Errorf("You must name the modules to reject or use -manifest")
+	}
+
+	q, err := openQuarantine()
+	if err != nil {
+		return err
+	}
+
+	modules, err := selectQuarantined(q, self.Flags.Args())
+	if err != nil {
+		return err
+	}
+
+	auditLog, err := openAuditLog()
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+
+	manifest := module.NewManifest("reject", currentOperator(), quarantineDir, "")
+	manifest.Reason = rejectReason
+	defer func() {
+		if saveErr := saveManifest(q, manifest); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	for _, m := range modules {
+		hashes, err := m.Hashes(q)
+		if err != nil {
+			return err
+		}
+
+		manifest.Add(m, hashes, nil)
+		if err := recordAudit(auditLog, manifest, m, hashes); err != nil {
+			return err
+		}
+
+		if err := removeQuarantined(q, m); err != nil {
+			return err
+		}
+		logger.Info("Rejected module", "module", m, "reason", rejectReason)
+	}
+
+	logger.Info(fmt.Sprintf("Rejected %v modules from %v", len(modules), quarantineDir),
+		"modules", len(modules), "quarantine", quarantineDir)
+	return nil
+}
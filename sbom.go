@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+	"github.com/haboustak/goff/internal/sbom"
+	"os"
+	"path/filepath"
+)
+
+var (
+	sbomFormat   string
+	sbomManifest string
+	sbomOutput   string
+)
+
+var cmdSbom = &Command{
+	Name: "sbom",
+	Run:  generateSbom,
+	Usage: `Usage:
+    goff [-h] sbom [-format cyclonedx|spdx] [-manifest id] [-output file]
+                   module_dir
+
+Write a software bill of materials for the modules in a module set
+
+module_dir is a directory, a .tar or .zip bundle of module files, or an
+S3-compatible bucket. With -manifest, only the modules listed in the
+manifest of a download or upload are included.
+
+Each module is identified by its package URL (pkg:golang/path@version) and
+lists the SHA-256 of its zip file, its go.mod and zip hashes from the
+checksum database (h1:), the licenses found in its zip file, and the
+modules required by its go.mod file. A requirement refers to the version
+that minimal version selection picks for the module's build list from the
+go.mod files in the module set, or the lowest higher version in the module
+set when that one is not listed.
+
+Options:
+    -format     write a CycloneDX 1.5 or SPDX 2.3 JSON document
+                (default=cyclonedx)
+    -h          show this help
+    -manifest   include the modules listed in a manifest
+    -output     write the document to a file (default=stdout)
+`,
+}
+
+func init() {
+	cmdSbom.Flags.StringVar(&sbomFormat, "format", sbom.FormatCycloneDX, "SBOM document format")
+	cmdSbom.Flags.StringVar(&sbomManifest, "manifest", "", "manifest ID of the modules to include")
+	cmdSbom.Flags.StringVar(&sbomOutput, "output", "", "output file")
+}
+
+func generateSbom(self *Command) error {
+	args := self.Flags.Args()
+	if len(args) == 0 {
+		return fmt.Errorf("You must specify the module set directory")
+	}
+
+	if sbomFormat != sbom.FormatCycloneDX && sbomFormat != sbom.FormatSPDX {
+		return fmt.Errorf("Unknown SBOM format %q, use cyclonedx or spdx", sbomFormat)
+	}
+
+	store, err := module.OpenStorage(args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to open module set %v: %v", args[0], err)
+	}
+
+	name := filepath.Base(args[0])
+	var modules []module.Module
+	if sbomManifest != "" {
+		manifest, err := module.LoadManifest(store, sbomManifest)
+		if err != nil {
+			return fmt.Errorf("Failed to read manifest %v: %v", sbomManifest, err)
+		}
+
+		for _, m := range manifest.Modules {
+			modules = append(modules, module.Module{Path: m.Path, Version: m.Version})
+		}
+		name = manifest.ID
+	} else if modules, err = module.StoredModules(store); err != nil {
+		return fmt.Errorf("Failed to read module set %v: %v", args[0], err)
+	}
+
+	bom, err := sbom.Build(name, store, modules)
+	if err != nil {
+		return fmt.Errorf("Failed to build SBOM: %v", err)
+	}
+	bom.Tool = "goff"
+	bom.ToolVersion = Version
+
+	if sbomOutput == "" {
+		return bom.Write(os.Stdout, sbomFormat)
+	}
+
+	out, err := os.Create(sbomOutput)
+	if err != nil {
+		return fmt.Errorf("Failed to create %v: %v", sbomOutput, err)
+	}
+	defer out.Close()
+
+	if err := bom.Write(out, sbomFormat); err != nil {
+		return fmt.Errorf("Failed to write SBOM: %v", err)
+	}
+
+	logger.Info("Wrote SBOM", "output", sbomOutput, "format", sbomFormat, "modules", len(bom.Components))
+	return nil
+}
@@ -1,64 +1,579 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/haboustak/goff/internal/audit"
+	"github.com/haboustak/goff/internal/module"
+	"github.com/haboustak/goff/internal/server"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 var (
-	bind    string
-	rootDir string
+	bind             string
+	rootDirs         []string
+	roots            []module.Storage
+	root             module.Storage
+	rootIndex        *module.Index
+	accelRedirect    string
+	presignExpiry    time.Duration
+	hideDeprecated   bool
+	upstreamProxy    string
+	upstream         *url.URL
+	upstreamSumDb    *sumdb.Client
+	recordFile       string
+	missingLog       *module.MissingLog
+	tlsCert          string
+	tlsKey           string
+	tlsClientCA      string
+	htpasswdFile     string
+	tokenFile        string
+	aclFile          string
+	auth             *server.Auth
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	idleTimeout      time.Duration
+	drainTimeout     time.Duration
+	draining         int32
+	accessLogFile    string
+	accessFormat     string
+	metricsPath      string
+	metrics          *server.Metrics
+	uploadEnabled    bool
+	uploadVerifyDb   bool
+	uploadMaxSize    int64
+	uploadTimeout    time.Duration
+	serveAuditLog    *audit.Log
+	servePolicy      *module.Policy
+	vulnDbPath       string
+	uploadQuarantine module.Storage
+	storedCounts     struct {
+		modules  int
+		versions int
+		updated  time.Time
+		sync.Mutex
+	}
 )
 
 var cmdServe = &Command{
 	Name: "serve",
 	Run:  serve,
 	Usage: `Usage:
-    goff [-h] serve [-bind IP:PORT] ROOT_DIR
+    goff [-h] serve [-bind IP:PORT] [-accel-redirect PREFIX] [-presign DURATION]
+                    [-hide-deprecated] [-upstream hostname] [-record file]
+                    [-tls-cert file -tls-key file [-tls-client-ca file]]
+                    [-htpasswd file] [-tokens file] [-acl file]
+                    [-read-timeout DURATION] [-write-timeout DURATION]
+                    [-idle-timeout DURATION] [-drain-timeout DURATION]
+                    [-metrics PATH] [-access-log file] [-access-log-format FORMAT]
+                    [-upload [-upload-verify=false] [-quarantine dir]
+                             [-upload-max-size N] [-upload-timeout DURATION]]
+                    [-audit-log file -audit-key file] [-policy file]
+                    [-vulndb PATH]
+                    ROOT_DIR [ROOT_DIR...]
+
+Start the HTTP proxy with modules stored in one or more ROOT_DIRs
+
+ROOT_DIR is a directory, a .tar or .zip bundle of module files, or an
+S3-compatible bucket given as s3://bucket/prefix?endpoint=URL&region=NAME.
+S3 credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+and AWS_SESSION_TOKEN environment variables. Bundles are served read-only
+and indexed at startup.
+
+When several ROOT_DIRs are given they are layered in priority order, highest
+first. @v/list and @latest consider the versions stored in every root, and
+each file is served from the first root that contains it.
+
+When a file is stored in a directory, it is served by the reverse proxy
+using an X-Accel-Redirect to PREFIX, or to PREFIX/N for the Nth ROOT_DIR
+(counting from 0) when there are several. When a file is stored in a bucket
+and -presign is set, clients are redirected to a presigned url. Otherwise
+goff serves the files itself.
+
+Versions retracted by the go.mod file of a module's latest version are not
+advertised by @v/list or returned by @latest. Pseudo-versions are not
+advertised by @v/list, but can be requested by commit hash. The .info Time
+decides which pseudo-version a commit hash, or @latest for a module without
+tagged versions, resolves to. @v/list is in semantic version order, which
+the go command uses to choose versions regardless of the order of the list.
+
+The go command reports the "// Deprecated:" comment of a module's latest
+go.mod file itself. -hide-deprecated also blocks new use of deprecated
+modules: @v/list and @latest answer 410 Gone with the deprecation message,
+so "go get" cannot resolve a version query for them. The .info, .mod and
+.zip files stay available, so builds that already require a deprecated
+module keep working.
+
+When -upstream is set, goff acts as a caching proxy. @v/list and @latest
+include the versions available upstream, and files that are not stored in
+any ROOT_DIR are downloaded from the upstream proxy, validated against the
+checksum database and stored in the first ROOT_DIR before they are served.
+Their .info files are checked like download does (see "goff help download").
+The first ROOT_DIR cannot be a bundle.
+
+When -record is set, every module or version that cannot be served is added
+to the record file once. Use download -from-missing to collect them.
+
+When -tls-cert and -tls-key are set, goff serves HTTPS. With -tls-client-ca,
+clients must also present a certificate signed by a CA in the bundle. The
+certificate, key and CA bundle are reloaded when goff receives SIGHUP.
+
+When -htpasswd or -tokens is set, clients must authenticate. Users log in
+with basic auth using a password from the htpasswd file (bcrypt, apr1 or
+SHA), or with a token sent as a bearer token or as their basic auth
+password, which works with the go command's .netrc and GOAUTH credentials.
+Each line of the tokens file is user:hash, where hash is the hex SHA-256 of
+the token. The -acl file limits which modules users can read and upload:
+
+    group NAME USER...
+    allow PREFIX GROUP...
+    upload PREFIX GROUP...
+
+A module can be read by the groups of the allow line with the longest
+PREFIX that matches its path, and uploaded by the groups of the upload line
+with the longest matching PREFIX. PREFIX is a module path, which may end in
+/..., and * matches every module or every user. goff refuses to start when a
+PREFIX is not a module path. The authentication files are reloaded when goff
+receives SIGHUP.
+
+On SIGTERM or SIGINT, goff stops accepting connections and waits for active
+requests to finish before it exits. /healthz and /readyz answer 200 when
+every ROOT_DIR can be read, and /readyz answers 503 once goff is stopping.
+They do not require authentication.
+
+Prometheus metrics are served at PATH, which does not require
+authentication. They count requests by endpoint type and status code,
+response bytes and latency, cache hits and misses when -upstream is set,
+and the number of modules and versions stored.
+
+When -access-log is set, every request is logged to the file, or to stdout
+if it is "-", in the Apache combined log format or as JSON lines. Requests
+are logged with the user they were authenticated as, if any.
+
+When -upload is set, module set tarballs, such as those written by download
+-outdir FILE.tar, can be POSTed to /upload. Their zip files are checked
+like upload does, their modules are validated against the checksum
+database, unless -upload-verify=false, and they are stored in the first
+ROOT_DIR, which cannot be a bundle. -upload requires -htpasswd or -tokens,
+and users can only upload modules the upload lines of the -acl file grant
+them. Tarballs larger than -upload-max-size are refused. Uploads are not
+limited by -read-timeout, but must be read within -upload-timeout, so the
+default 1GiB tarball needs a client that sends at least 600KB/s. Over
+HTTP/2, uploads are limited by -write-timeout instead. Each upload is
+listed in a manifest and, with -audit-log and -audit-key, added to the audit
+log under the authenticated user. The response is JSON with the manifest ID
+and the uploaded modules.
+
+With -quarantine, uploaded modules that are not already served are stored
+in the quarantine instead of the first ROOT_DIR, along with the manifest.
+The quarantine is not served, and its modules are moved to a ROOT_DIR by
+"goff approve" or deleted by "goff reject".
+
+When -policy is set, modules fetched from upstream and uploaded modules are
+checked against the allow and deny rules of the policy file (see "goff help
+download"). Denied module versions are answered with 403 and the reason.
+
+A snapshot of the Go vulnerability database written by download -vulndb is
+served from the first ROOT_DIR that has it at PATH, with the index and ID
+files govulncheck reads, so modules can be scanned offline:
 
-Start the HTTP proxy with modules stored in ROOT_DIR
+    GOVULNDB=https://goff.example.com/vulndb govulncheck ./...
 
 Options:
+    -accel-redirect
+            Set the X-Accel-Redirect location of ROOT_DIRs, or "" to serve
+            files directly (default=/modules)
+    -access-log
+            Append a line for every request to a file, or "-" for stdout
+    -access-log-format
+            Write the access log as combined or json lines (default=combined)
+    -acl    file of groups and the module path prefixes they can read
+    -audit-log
+            append uploaded modules to an audit log (default=GOFF_AUDIT_LOG)
+    -audit-key
+            key used to sign audit records (default=GOFF_AUDIT_KEY)
     -bind   Set the IP and port used by the HTTP server (default=localhost:5000)
+    -drain-timeout
+            Set how long active requests can run after SIGTERM (default=30s)
     -h      show this help
+    -htpasswd
+            htpasswd file of users that can log in with basic auth
+    -metrics
+            Set the path of the Prometheus metrics, or "" to disable them
+            (default=/metrics)
+    -idle-timeout
+            Set how long idle keep-alive connections stay open (default=2m)
+    -policy file of module allow and deny rules (default=GOFF_POLICY)
+    -presign
+            Redirect clients to presigned S3 urls that expire after DURATION,
+            or 0 to serve files directly (default=0)
+    -quarantine
+            store uploaded modules in a directory or bucket until they are
+            approved (default=GOFF_QUARANTINE)
+    -read-timeout
+            Set the time allowed to read a request (default=30s)
+    -record Append modules that are requested but not stored to a file
+    -hide-deprecated
+            Answer @v/list and @latest for deprecated modules with 410 Gone
+    -tls-cert
+            PEM certificate chain used to serve HTTPS
+    -tls-client-ca
+            PEM bundle of CAs that sign client certificates
+    -tls-key
+            PEM private key of the -tls-cert certificate
+    -tokens file of users and the SHA-256 hashes of their bearer tokens
+    -upload accept module set tarballs at POST /upload
+    -upload-max-size
+            largest module set tarball accepted, in bytes (default=1GiB)
+    -upload-timeout
+            Set the time allowed to read an upload (default=30m)
+    -upload-verify
+            validate uploaded modules against the checksum database
+            (default=true)
+    -upstream
+            hostname of a proxy used to fetch modules that are not stored
+    -vulndb Set the path of the vulnerability database, or "" to disable it
+            (default=/vulndb)
+    -write-timeout
+            Set the time allowed to write a response, or 0 for no limit
+            (default=10m)
 `,
 }
 
 func init() {
 	cmdServe.Flags.StringVar(&bind, "bind", "localhost:5000", "Set the IP and port used by the HTTP server (default=localhost:5000)")
+	cmdServe.Flags.StringVar(&accelRedirect, "accel-redirect", "/modules", "Set the X-Accel-Redirect location of ROOT_DIR")
+	cmdServe.Flags.DurationVar(&presignExpiry, "presign", 0, "Redirect clients to presigned S3 urls that expire after this duration")
+	cmdServe.Flags.BoolVar(&hideDeprecated, "hide-deprecated", false, "Hide deprecated modules from @v/list and @latest")
+	cmdServe.Flags.StringVar(&upstreamProxy, "upstream", "", "hostname of proxy used to fetch missing modules")
+	cmdServe.Flags.StringVar(&recordFile, "record", "", "file that lists requested modules that are not stored")
+	cmdServe.Flags.StringVar(&tlsCert, "tls-cert", "", "PEM certificate chain used to serve HTTPS")
+	cmdServe.Flags.StringVar(&tlsKey, "tls-key", "", "PEM private key of the certificate")
+	cmdServe.Flags.StringVar(&tlsClientCA, "tls-client-ca", "", "PEM bundle of CAs that sign client certificates")
+	cmdServe.Flags.StringVar(&htpasswdFile, "htpasswd", "", "htpasswd file of users")
+	cmdServe.Flags.StringVar(&tokenFile, "tokens", "", "file of users and bearer token hashes")
+	cmdServe.Flags.StringVar(&aclFile, "acl", "", "file of module path access rules")
+	cmdServe.Flags.DurationVar(&readTimeout, "read-timeout", 30*time.Second, "time allowed to read a request")
+	cmdServe.Flags.DurationVar(&writeTimeout, "write-timeout", 10*time.Minute, "time allowed to write a response")
+	cmdServe.Flags.DurationVar(&idleTimeout, "idle-timeout", 2*time.Minute, "time idle connections stay open")
+	cmdServe.Flags.DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "time active requests can run after SIGTERM")
+	cmdServe.Flags.StringVar(&metricsPath, "metrics", "/metrics", "path of the Prometheus metrics")
+	cmdServe.Flags.StringVar(&accessLogFile, "access-log", "", "file that logs every request")
+	cmdServe.Flags.StringVar(&accessFormat, "access-log-format", server.AccessLogCombined, "format of the access log")
+	cmdServe.Flags.BoolVar(&uploadEnabled, "upload", false, "accept module set tarballs at POST /upload")
+	cmdServe.Flags.BoolVar(&uploadVerifyDb, "upload-verify", true, "validate uploaded modules against the checksum database")
+	cmdServe.Flags.Int64Var(&uploadMaxSize, "upload-max-size", 1<<30, "largest module set tarball accepted, in bytes")
+	cmdServe.Flags.DurationVar(&uploadTimeout, "upload-timeout", 30*time.Minute, "time allowed to read an upload")
+	cmdServe.Flags.StringVar(&auditLogFile, "audit-log", os.Getenv("GOFF_AUDIT_LOG"), "audit log file")
+	cmdServe.Flags.StringVar(&auditKeyFile, "audit-key", os.Getenv("GOFF_AUDIT_KEY"), "audit log signing key")
+	cmdServe.Flags.StringVar(&vulnDbPath, "vulndb", "/vulndb", "path of the vulnerability database")
+	addQuarantineFlag(&cmdServe.Flags, "storage that holds uploaded modules until they are approved")
+	addPolicyFlag(&cmdServe.Flags)
 }
 
 func serve(self *Command) error {
 	args := self.Flags.Args()
-	if len(args) != 1 {
+	if len(args) == 0 {
 		return fmt.Errorf("You must provide the root directory of the package storage")
 	}
 
-	rootDir = args[0]
-	rootInfo, err := os.Stat(rootDir)
-	if os.IsNotExist(err) {
-		return fmt.Errorf("The path \"%v\" does not exist.", rootDir)
+	if (tlsCert == "") != (tlsKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be used together")
+	}
+
+	if tlsClientCA != "" && tlsCert == "" {
+		return fmt.Errorf("-tls-client-ca requires -tls-cert and -tls-key")
+	}
+
+	if aclFile != "" && htpasswdFile == "" && tokenFile == "" {
+		return fmt.Errorf("-acl requires -htpasswd or -tokens")
+	}
+
+	if uploadEnabled && htpasswdFile == "" && tokenFile == "" {
+		return fmt.Errorf("-upload requires -htpasswd or -tokens")
+	}
+
+	rootDirs = args
+	vulnDbPath = strings.TrimSuffix(vulnDbPath, "/")
+	if upstreamProxy != "" {
+		if module.IsBundle(rootDirs[0]) {
+			return fmt.Errorf("Modules fetched from upstream cannot be stored in the bundle %v", rootDirs[0])
+		}
+
+		upstream = proxyUrl(upstreamProxy)
+	}
+
+	if uploadEnabled && uploadTimeout <= 0 {
+		return fmt.Errorf("The -upload-timeout must be greater than 0")
+	}
+
+	if uploadEnabled && module.IsBundle(rootDirs[0]) {
+		return fmt.Errorf("Uploaded modules cannot be stored in the bundle %v", rootDirs[0])
+	}
+
+	if upstream != nil || (uploadEnabled && uploadVerifyDb) {
+		dbUrl, _ := url.Parse("https://sum.golang.org")
+		upstreamSumDb = module.NewClient(dbUrl, logger)
+	}
+
+	if policyFile != "" {
+		var err error
+		if servePolicy, err = loadPolicy(); err != nil {
+			return err
+		}
+	}
+
+	if uploadEnabled && quarantineDir != "" {
+		if err := checkQuarantineDir(rootDirs); err != nil {
+			return err
+		}
+
+		var err error
+		if uploadQuarantine, err = openQuarantine(); err != nil {
+			return err
+		}
+	}
+
+	if uploadEnabled {
+		var err error
+		if serveAuditLog, err = openAuditLog(); err != nil {
+			return err
+		}
+		if serveAuditLog != nil {
+			defer serveAuditLog.Close()
+		}
+	}
+
+	allBundles := true
+	for _, rootDir := range rootDirs {
+		layer, err := openRoot(rootDir)
+		if err != nil {
+			return err
+		}
+
+		roots = append(roots, layer)
+		allBundles = allBundles && module.IsBundle(rootDir)
+	}
+
+	root = roots[0]
+	if len(roots) > 1 {
+		root = module.NewLayeredStorage(roots...)
+	}
+
+	// Bundles never change, so their module versions are only read once
+	if allBundles {
+		var err error
+		if rootIndex, err = module.BuildIndex(root); err != nil {
+			return fmt.Errorf("Failed to index %v: %v", strings.Join(rootDirs, ", "), err)
+		}
+
+		nModules, nVersions := rootIndex.Count()
+		logger.Info("Indexed bundles", "modules", nModules, "versions", nVersions)
+	}
+
+	if recordFile != "" {
+		var err error
+		if missingLog, err = module.OpenMissingLog(recordFile); err != nil {
+			return fmt.Errorf("Failed to open record file %v: %v", recordFile, err)
+		}
+		defer missingLog.Close()
+	}
+
+	if htpasswdFile != "" || tokenFile != "" {
+		var err error
+		if auth, err = server.LoadAuth(htpasswdFile, tokenFile, aclFile); err != nil {
+			return fmt.Errorf("Failed to load authentication: %v", err)
+		}
+
+		server.ReloadOnSignal(auth, func(err error) {
+			logger.Error("Failed to reload authentication", "error", err)
+		}, syscall.SIGHUP)
+	}
+
+	metrics = server.NewMetrics()
+	metrics.Gauge("goff_stored_modules", "Modules stored in the roots", func() float64 {
+		modules, _ := countStored()
+		return float64(modules)
+	})
+	metrics.Gauge("goff_stored_versions", "Module versions stored in the roots", func() float64 {
+		_, versions := countStored()
+		return float64(versions)
+	})
+
+	handler := metrics.Instrument(router, endpointType)
+	if accessLogFile != "" {
+		accessOut := os.Stdout
+		if accessLogFile != "-" {
+			var err error
+			if accessOut, err = os.OpenFile(accessLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644); err != nil {
+				return fmt.Errorf("Failed to open access log: %v", err)
+			}
+			defer accessOut.Close()
+		}
+
+		accessLog, err := server.NewAccessLog(accessOut, accessFormat)
+		if err != nil {
+			return err
+		}
+		handler = accessLog.Wrap(handler)
+	}
+
+	http.HandleFunc("/", handler)
+	httpServer := &http.Server{
+		Addr:              bind,
+		ReadHeaderTimeout: readTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	// ReadTimeout covers reading the whole request body and would cut off
+	// large uploads, so the router sets the read deadline of each request
+	if uploadEnabled {
+		httpServer.ReadTimeout = 0
+		httpServer.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, conn)
+		}
+	}
+
+	if tlsCert != "" {
+		certs, err := server.LoadCertificates(tlsCert, tlsKey, tlsClientCA)
+		if err != nil {
+			return err
+		}
+
+		server.ReloadOnSignal(certs, func(err error) {
+			logger.Error("Failed to reload certificates", "error", err)
+		}, syscall.SIGHUP)
+		httpServer.TLSConfig = certs.TLSConfig()
+	}
+
+	stopped := make(chan error, 1)
+	go drainOnSignal(httpServer, stopped)
+
+	var err error
+	if tlsCert != "" {
+		logger.Info("Serving modules over HTTPS", "roots", strings.Join(rootDirs, ","), "bind", bind)
+		err = httpServer.ListenAndServeTLS("", "")
+	} else {
+		logger.Info("Serving modules", "roots", strings.Join(rootDirs, ","), "bind", bind)
+		err = httpServer.ListenAndServe()
+	}
+
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	return <-stopped
+}
+
+// Stop the server on SIGTERM or SIGINT after active requests finish
+//
+// The result of the shutdown is sent to stopped.
+func drainOnSignal(httpServer *http.Server, stopped chan<- error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	received := <-signals
+	atomic.StoreInt32(&draining, 1)
+	logger.Info("Waiting for active requests", "signal", received, "timeout", drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		stopped <- fmt.Errorf("Failed to stop the server: %v", err)
+		return
+	}
+
+	stopped <- nil
+}
+
+// Open a root directory, bundle or bucket for serving
+func openRoot(rootDir string) (module.Storage, error) {
+	if module.IsBundle(rootDir) {
+		return module.OpenBundle(rootDir)
+	}
+
+	layer, err := module.OpenStorage(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if fileRoot, isDir := layer.(*module.FileStorage); isDir {
+		rootInfo, err := os.Stat(fileRoot.Dir)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("The path \"%v\" does not exist.", rootDir)
+		}
+
+		if !rootInfo.IsDir() {
+			return nil, fmt.Errorf("The path \"%v\" is not a directory.", rootDir)
+		}
 	}
 
-	if !rootInfo.IsDir() {
-		return fmt.Errorf("The path \"%v\" is not a directory.", rootDir)
+	return layer, nil
+}
+
+// Find the root that serves a file
+//
+// Returns the root's position in the list of ROOT_DIRs along with the root.
+func locate(name string) (int, module.Storage, error) {
+	if layered, isLayered := root.(*module.LayeredStorage); isLayered {
+		return layered.Locate(name)
 	}
 
-	fmt.Printf("Serving modules from %v\n", rootDir)
-	http.HandleFunc("/", router)
-	http.ListenAndServe(bind, nil)
+	if _, err := root.Stat(name); err != nil {
+		return -1, nil, err
+	}
 
-	return nil
+	return 0, root, nil
 }
 
 func router(writer http.ResponseWriter, request *http.Request) {
 	requestPath := request.URL.Path
-	if strings.HasSuffix(requestPath, "/@v/list") {
+	if uploadEnabled && requestPath != "/upload" {
+		setReadDeadline(request, readTimeout)
+	}
+
+	if requestPath == "/healthz" || requestPath == "/readyz" {
+		health(writer, request)
+		return
+	}
+
+	if metricsPath != "" && requestPath == metricsPath {
+		metrics.ServeHTTP(writer, request)
+		return
+	}
+
+	if !authorize(writer, request) {
+		return
+	}
+
+	if uploadEnabled && requestPath == "/upload" {
+		uploadTarball(writer, request)
+	} else if isVulnDbRequest(requestPath) {
+		vulnDb(writer, request)
+	} else if strings.HasSuffix(requestPath, "/@v/list") {
 		list(writer, request)
+	} else if strings.HasSuffix(requestPath, "/@latest") {
+		latest(writer, request)
+	} else if strings.HasSuffix(requestPath, ".info") {
+		info(writer, request)
 	} else if requestPath == "/" {
 		home(writer, request)
 	} else {
@@ -66,39 +581,640 @@ func router(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
+// Get the type of a request for metrics
+func endpointType(request *http.Request) string {
+	requestPath := request.URL.Path
+	switch {
+	case isVulnDbRequest(requestPath):
+		return "vulndb"
+	case strings.HasPrefix(requestPath, "/sumdb/"):
+		// The checksum database is not proxied, so its tiles are not counted
+		// as module files
+		return "other"
+	case strings.HasSuffix(requestPath, "/@v/list"):
+		return "list"
+	case strings.HasSuffix(requestPath, "/@latest"):
+		return "latest"
+	case strings.HasSuffix(requestPath, ".info"):
+		return "info"
+	case strings.HasSuffix(requestPath, ".mod"):
+		return "mod"
+	case strings.HasSuffix(requestPath, ".zip"):
+		return "zip"
+	case requestPath == "/healthz" || requestPath == "/readyz":
+		return "health"
+	case metricsPath != "" && requestPath == metricsPath:
+		return "metrics"
+	case uploadEnabled && requestPath == "/upload":
+		return "upload"
+	}
+
+	return "other"
+}
+
+// Count the modules and versions in the roots
+//
+// Counting requires reading every root, so the counts are only updated once
+// a minute.
+func countStored() (int, int) {
+	storedCounts.Lock()
+	defer storedCounts.Unlock()
+
+	if time.Since(storedCounts.updated) < time.Minute {
+		return storedCounts.modules, storedCounts.versions
+	}
+
+	if rootIndex != nil {
+		storedCounts.modules, storedCounts.versions = rootIndex.Count()
+	} else if stored, err := module.StoredModules(root); err == nil {
+		paths := make(map[string]bool)
+		for _, m := range stored {
+			paths[m.Path] = true
+		}
+		storedCounts.modules, storedCounts.versions = len(paths), len(stored)
+	}
+	storedCounts.updated = time.Now()
+
+	return storedCounts.modules, storedCounts.versions
+}
+
+// Check that the client can read the requested module
+//
+// Writes an error response and returns false when the client is not
+// authenticated or is not allowed to read the module.
+func authorize(writer http.ResponseWriter, request *http.Request) bool {
+	if auth == nil {
+		return true
+	}
+
+	user, ok := auth.Authenticate(request)
+	if !ok {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="goff"`)
+		http.Error(writer, "Authentication required", http.StatusUnauthorized)
+		return false
+	}
+	server.SetUser(request, user)
+
+	// Only module requests are restricted by the access rules
+	requestPath := request.URL.Path
+	modPath := ""
+	if strings.Contains(requestPath, "/@v/") {
+		modPath = strings.SplitN(requestPath, "/@v/", 2)[0]
+	} else if strings.HasSuffix(requestPath, "/@latest") {
+		modPath = strings.TrimSuffix(requestPath, "/@latest")
+	} else {
+		return true
+	}
+
+	m, err := module.ParseEscaped(strings.TrimPrefix(modPath, "/"), "")
+	if err != nil || !auth.Allowed(user, m.Path) {
+		logger.Warn("Access denied", "path", requestPath, "user", user)
+		http.Error(writer, "Access denied", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
 func list(writer http.ResponseWriter, request *http.Request) {
-	println(request.URL.Path)
+	logger.Debug("Request", "path", request.URL.Path)
 
 	modPath := strings.TrimSuffix(request.URL.Path, "/@v/list")[1:]
-	versionGlob := path.Join(rootDir, modPath, "*.info")
-	fmt.Printf("Searching %v\n", versionGlob)
+	versions, err := storedVersions(modPath)
+	if upstream != nil {
+		if merged, upstreamErr := addUpstreamVersions(modPath, versions); upstreamErr == nil {
+			versions, err = merged, nil
+		}
+	}
 
-	versionFiles, err := filepath.Glob(versionGlob)
-	if err != nil || len(versionFiles) == 0 {
+	if err != nil || len(versions) == 0 {
+		recordMissing(modPath, "")
 		http.Error(
 			writer,
-			fmt.Sprintf("Search failed %v: %v", versionGlob, err),
+			fmt.Sprintf("Search failed %v: %v", modPath, err),
 			http.StatusNotFound)
+		return
+	}
+
+	status := storedStatus(modPath, versions)
+	if hideDeprecated && status != nil && status.Deprecated != "" {
+		deprecated(writer, modPath, status)
+		return
 	}
 
-	for _, versionFile := range versionFiles {
-		version := strings.TrimSuffix(path.Base(versionFile), ".info")
+	// Tagged versions are ordered by semver precedence like the go command
+	// orders them, rather than by Time, which would mean reading the .info
+	// file of every version on each request
+	semver.Sort(versions)
+	for _, version := range versions {
+		// Pseudo-versions are only available by name or commit hash
+		if module.IsPseudoVersion(version) {
+			continue
+		}
+
+		// Keep the version that declares the retractions, so that the go
+		// command can still find and report them
+		if retracted, _ := status.IsRetracted(version); retracted && version != status.Version {
+			continue
+		}
 		fmt.Fprintf(writer, "%v\n", version)
 	}
 }
 
+func latest(writer http.ResponseWriter, request *http.Request) {
+	logger.Debug("Request", "path", request.URL.Path)
+
+	modPath := strings.TrimSuffix(request.URL.Path, "/@latest")[1:]
+	versions, err := storedVersions(modPath)
+	status := storedStatus(modPath, versions)
+	if hideDeprecated && status != nil && status.Deprecated != "" {
+		deprecated(writer, modPath, status)
+		return
+	}
+
+	if upstream != nil {
+		if infoBytes, err := fetchUpstreamInfo(modPath, "latest"); err == nil {
+			writer.Header().Set("Content-Type", "application/json")
+			writer.Write(infoBytes)
+			return
+		}
+	}
+
+	if err != nil || len(versions) == 0 {
+		recordMissing(modPath, "")
+		http.Error(
+			writer,
+			fmt.Sprintf("Search failed %v: %v", modPath, err),
+			http.StatusNotFound)
+		return
+	}
+
+	// Modules without tagged versions use their most recent pseudo-version
+	version := module.SelectLatest(versions, status)
+	if version == "" {
+		version = newestPseudoVersion(modPath, versions, func(v string) bool {
+			retracted, _ := status.IsRetracted(v)
+			return !retracted
+		})
+	}
+
+	_, infoBytes, err := storedInfo(modPath, version)
+	if err != nil {
+		http.Error(
+			writer,
+			fmt.Sprintf("Failed to read %v@%v: %v", modPath, version, err),
+			http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(infoBytes)
+}
+
+// Serve .info files, resolving commit hashes to stored pseudo-versions
+func info(writer http.ResponseWriter, request *http.Request) {
+	modValues := strings.Split(request.URL.Path, "/@v/")
+	if len(modValues) != 2 {
+		http.NotFound(writer, request)
+		return
+	}
+
+	modPath := modValues[0][1:]
+	query := strings.TrimSuffix(modValues[1], ".info")
+	if _, err := root.Stat(path.Join(modPath, modValues[1])); err == nil {
+		redirect(writer, request)
+		return
+	}
+
+	logger.Debug("Request", "path", request.URL.Path)
+
+	versions, err := storedVersions(modPath)
+	if err != nil && upstream == nil {
+		recordMissing(modPath, query)
+		http.NotFound(writer, request)
+		return
+	}
+
+	version := newestPseudoVersion(modPath, versions, func(v string) bool {
+		return module.MatchesRevision(v, query)
+	})
+	_, infoBytes, err := storedInfo(modPath, version)
+
+	if upstream != nil {
+		metrics.ObserveCache(err == nil)
+	}
+
+	if err != nil && upstream != nil {
+		if infoBytes, err = fetchUpstreamInfo(modPath, query); err != nil {
+			logger.Warn("Failed to fetch from upstream", "path", request.URL.Path, "error", err)
+		}
+	}
+
+	if denied(writer, err) {
+		return
+	}
+
+	if err != nil {
+		recordMissing(modPath, query)
+		http.Error(
+			writer,
+			fmt.Sprintf("Unknown revision %v of %v", query, modPath),
+			http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(infoBytes)
+}
+
+// Find the pseudo-version that passes match with the newest .info Time
+//
+// Returns an empty string if no pseudo-version matches.
+func newestPseudoVersion(modPath string, versions []string, match func(string) bool) string {
+	newest := ""
+	var newestInfo *module.VersionInfo
+	for _, version := range versions {
+		if !module.IsPseudoVersion(version) || !match(version) {
+			continue
+		}
+
+		versionInfo, _, err := storedInfo(modPath, version)
+		if err != nil {
+			continue
+		}
+
+		if newestInfo == nil || versionInfo.Time.After(newestInfo.Time) ||
+			(versionInfo.Time.Equal(newestInfo.Time) && semver.Compare(version, newest) > 0) {
+			newest = version
+			newestInfo = versionInfo
+		}
+	}
+
+	return newest
+}
+
+// Read and decode the stored .info file for a version of a module
+func storedInfo(modPath string, version string) (*module.VersionInfo, []byte, error) {
+	if rootIndex != nil {
+		indexed, err := indexedModule(modPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		versionInfo, infoBytes, ok := indexed.Info(version)
+		if !ok {
+			return nil, nil, fmt.Errorf("Version %v of %v is not stored", version, modPath)
+		}
+		return versionInfo, infoBytes, nil
+	}
+
+	infoPath := path.Join(modPath, module.Module{Version: version}.EscapedVersion()+".info")
+	infoBytes, err := module.ReadFile(root, infoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versionInfo := new(module.VersionInfo)
+	if err := json.Unmarshal(infoBytes, versionInfo); err != nil {
+		return nil, nil, fmt.Errorf("Invalid info file %v: %v", infoPath, err)
+	}
+
+	return versionInfo, infoBytes, nil
+}
+
+// Download a module file that is missing from the root from the upstream proxy
+//
+// The version's .info file is stored as well, so that the version is listed
+// by @v/list. Versions other than pseudo-versions must be listed by the
+// upstream @v/list, and .info files that do not match the version are
+// reported as security errors.
+func fetchUpstream(modPath string, fileName string) error {
+	fileType := module.ModuleFileType(path.Ext(fileName))
+	if fileType != module.ModFileTypeInfo && fileType != module.ModFileTypeModule && fileType != module.ModFileTypeZip {
+		return fmt.Errorf("Unknown module file %v", fileName)
+	}
+
+	m, err := module.ParseEscaped(modPath, "")
+	if err != nil {
+		return err
+	}
+
+	if m.Version, err = module.FileVersion(fileName, fileType); err != nil {
+		return err
+	}
+
+	if err := m.Check(); err != nil {
+		return err
+	}
+
+	if err := servePolicy.Check(m); err != nil {
+		return err
+	}
+
+	logger.Info("Fetching from upstream", "file", path.Join(modPath, "@v", fileName), "upstream", upstream)
+	if !module.IsPseudoVersion(m.Version) {
+		versions, err := m.Versions(upstream)
+		if err != nil {
+			return err
+		}
+
+		err = module.CheckListed(m, versions)
+		if err != nil {
+			logger.Security("Upstream returned invalid module metadata", "module", m, "upstream", upstream, "error", err)
+			return err
+		}
+	}
+
+	switch fileType {
+	case module.ModFileTypeZip:
+		err = m.Download(upstream, root, upstreamSumDb, servePolicy)
+	case module.ModFileTypeModule:
+		if err = m.ModuleFile().Download(upstream, root, upstreamSumDb); err == nil {
+			err = m.InfoFile().Download(upstream, root, upstreamSumDb)
+		}
+	default:
+		err = m.InfoFile().Download(upstream, root, upstreamSumDb)
+	}
+
+	if _, invalid := err.(*module.InfoError); invalid {
+		logger.Security("Upstream returned invalid module metadata", "module", m, "upstream", upstream, "error", err)
+	}
+
+	return err
+}
+
+// Resolve a version query with the upstream proxy and store the .info file
+// of the version it resolves to
+//
+// query is "latest", a canonical version or a revision such as a branch name
+// or commit hash.
+func fetchUpstreamInfo(modPath string, query string) ([]byte, error) {
+	m, err := module.ParseEscaped(modPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	m.Version = query
+	if m.Version, err = m.Query(upstream, ""); err != nil {
+		return nil, fmt.Errorf("Failed to resolve %v@%v with %v: %v", m.Path, query, upstream, err)
+	}
+
+	if err := m.Check(); err != nil {
+		return nil, err
+	}
+
+	if err := fetchUpstream(modPath, m.InfoFile().FileName); err != nil {
+		return nil, err
+	}
+
+	_, infoBytes, err := storedInfo(modPath, m.Version)
+	return infoBytes, err
+}
+
+// Add the versions listed by the upstream proxy to the stored versions
+func addUpstreamVersions(modPath string, versions []string) ([]string, error) {
+	m, err := module.ParseEscaped(modPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	upstreamVersions, err := m.Versions(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make([]string, len(versions))
+	copy(merged, versions)
+
+	seen := make(map[string]bool)
+	for _, version := range versions {
+		seen[version] = true
+	}
+
+	for _, version := range upstreamVersions {
+		if seen[version] || !semver.IsValid(version) {
+			continue
+		}
+		seen[version] = true
+		merged = append(merged, version)
+	}
+
+	return merged, nil
+}
+
+// Add a module that could not be served to the record file
+//
+// modPath and version are escaped as they are in proxy requests. version is
+// empty when the request did not name a version.
+func recordMissing(modPath string, version string) {
+	if missingLog == nil {
+		return
+	}
+
+	m, err := module.ParseEscaped(modPath, version)
+	if err != nil {
+		return
+	}
+
+	if err := missingLog.Record(m); err != nil {
+		logger.Error("Failed to record missing module", "module", m, "error", err)
+	}
+}
+
+// Answer a request for a module version that is denied by the policy with
+// the reason
+//
+// Returns false if err is not a policy violation.
+func denied(writer http.ResponseWriter, err error) bool {
+	var violation *module.PolicyViolation
+	if !errors.As(err, &violation) {
+		return false
+	}
+
+	http.Error(writer, violation.Error(), http.StatusForbidden)
+	return true
+}
+
+// Answer a version query for a deprecated module with -hide-deprecated
+//
+// Only @v/list and @latest are blocked, the files of each version are still
+// served so that existing requirements can be downloaded.
+func deprecated(writer http.ResponseWriter, modPath string, status *module.ModuleStatus) {
+	http.Error(
+		writer,
+		fmt.Sprintf("Module %v is deprecated: %v", modPath, status.Deprecated),
+		http.StatusGone)
+}
+
+// Get the versions of a module stored in the root
+//
+// modPath is the escaped module path used in proxy requests. The versions
+// are a copy that callers can sort or append to, the index is shared by
+// every request.
+func storedVersions(modPath string) ([]string, error) {
+	if rootIndex != nil {
+		indexed, err := indexedModule(modPath)
+		if err != nil {
+			return nil, err
+		}
+		return append([]string(nil), indexed.Versions...), nil
+	}
+
+	m, err := module.ParseEscaped(modPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return module.StoredVersions(root, m.Path)
+}
+
+// Find a module in the root's index
+func indexedModule(modPath string) (*module.IndexedModule, error) {
+	m, err := module.ParseEscaped(modPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	indexed := rootIndex.Module(m.Path)
+	if indexed == nil {
+		return nil, fmt.Errorf("Module %v is not stored", m.Path)
+	}
+
+	return indexed, nil
+}
+
+// Read retractions and the deprecation notice from the go.mod file of the
+// latest stored version of a module
+//
+// Returns nil if the go.mod file is not available.
+func storedStatus(modPath string, versions []string) *module.ModuleStatus {
+	if rootIndex != nil {
+		indexed, err := indexedModule(modPath)
+		if err != nil {
+			return nil
+		}
+		return indexed.Status
+	}
+
+	latest := module.SelectLatest(versions, nil)
+	if latest == "" {
+		return nil
+	}
+
+	m, err := module.ParseEscaped(modPath, "")
+	if err != nil {
+		return nil
+	}
+	m.Version = latest
+
+	modFile := m.ModuleFile()
+	modFileBytes, err := module.ReadFile(root, modFile.FilePath)
+	if err != nil {
+		return nil
+	}
+
+	status, err := module.ParseModuleStatus(m.Path, latest, modFileBytes)
+	if err != nil {
+		return nil
+	}
+
+	return status
+}
+
 func redirect(writer http.ResponseWriter, request *http.Request) {
-	println(request.URL.Path)
+	logger.Debug("Request", "path", request.URL.Path)
 	modValues := strings.Split(request.URL.Path, "/@v/")
 	if len(modValues) == 1 {
 		http.NotFound(writer, request)
 		return
 	}
 
-	resourcePath := path.Join("/modules", modValues[0], modValues[1])
-	println(fmt.Sprintf("Redirecting to %v", resourcePath))
-	writer.Header().Set("X-Accel-Redirect", resourcePath)
-	fmt.Fprintf(writer, "")
+	filePath := path.Join(modValues[0], modValues[1])
+	layer, fileRoot, err := locate(filePath)
+	if upstream != nil {
+		metrics.ObserveCache(err == nil)
+	}
+
+	if err != nil && upstream != nil {
+		if err = fetchUpstream(strings.TrimPrefix(modValues[0], "/"), modValues[1]); err == nil {
+			layer, fileRoot, err = locate(filePath)
+		} else {
+			logger.Warn("Failed to fetch from upstream", "path", request.URL.Path, "error", err)
+		}
+	}
+
+	if denied(writer, err) {
+		return
+	}
+
+	if err != nil {
+		fileName := modValues[1]
+		recordMissing(strings.TrimPrefix(modValues[0], "/"), strings.TrimSuffix(fileName, path.Ext(fileName)))
+		http.NotFound(writer, request)
+		return
+	}
+
+	// Let the reverse proxy serve files from a directory
+	if _, isDir := fileRoot.(*module.FileStorage); isDir && accelRedirect != "" {
+		location := accelRedirect
+		if len(roots) > 1 {
+			location = path.Join(accelRedirect, strconv.Itoa(layer))
+		}
+
+		resourcePath := path.Join(location, filePath)
+		logger.Debug("Redirecting", "path", request.URL.Path, "location", resourcePath)
+		writer.Header().Set("X-Accel-Redirect", resourcePath)
+		fmt.Fprintf(writer, "")
+		return
+	}
+
+	// Let the client download files directly from object storage
+	if presigner, canPresign := fileRoot.(module.Presigner); canPresign && presignExpiry > 0 {
+		fileUrl, err := presigner.PresignGet(filePath, presignExpiry)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logger.Debug("Redirecting", "path", request.URL.Path, "location", fileUrl)
+		http.Redirect(writer, request, fileUrl, http.StatusFound)
+		return
+	}
+
+	file, err := fileRoot.Open(filePath)
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeContent(writer, request, modValues[1], fileInfo.ModTime(), file)
+}
+
+// Report whether every root can be read, and whether the server is stopping
+func health(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path == "/readyz" && atomic.LoadInt32(&draining) != 0 {
+		http.Error(writer, "Shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	for i, layer := range roots {
+		if _, err := layer.List("."); err != nil {
+			http.Error(
+				writer,
+				fmt.Sprintf("Failed to read %v: %v", rootDirs[i], err),
+				http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	fmt.Fprintf(writer, "ok\n")
 }
 
 func home(writer http.ResponseWriter, request *http.Request) {
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/haboustak/goff/internal/module"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// The context key of the connection a request was read from
+type connKey struct{}
+
+// The response to an upload request
+type uploadResult struct {
+	Manifest string   `json:"manifest,omitempty"`
+	Uploaded int      `json:"uploaded"`
+	Modules  []string `json:"modules"`
+
+	// The modules were stored in the quarantine and must be approved
+	Quarantined bool `json:"quarantined,omitempty"`
+}
+
+// Store the modules of a module set tarball sent in the request body
+//
+// Modules already in the first root are skipped. With -quarantine, modules
+// that are not served by any root are stored in the quarantine instead. The
+// uploaded modules are listed in a manifest and added to the audit log, and
+// the manifest ID and modules are returned as JSON.
+//
+// The router has already authenticated the request, serve refuses -upload
+// without authentication.
+func uploadTarball(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	operator, _ := auth.Authenticate(request)
+	logger.Info("Upload", "operator", operator, "remote", request.RemoteAddr)
+
+	extendDeadlines(request)
+	if request.ContentLength > uploadMaxSize {
+		http.Error(writer, fmt.Sprintf("Upload is larger than %v bytes", uploadMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	tarball, err := os.CreateTemp("", "goff-upload-*.tar")
+	if err != nil {
+		logger.Error("Failed to create upload file", "error", err)
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tarball.Name())
+
+	size, err := io.Copy(tarball, http.MaxBytesReader(writer, request.Body, uploadMaxSize))
+	tarball.Close()
+	if err != nil && size >= uploadMaxSize {
+		http.Error(writer, fmt.Sprintf("Upload is larger than %v bytes", uploadMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		http.Error(writer, fmt.Sprintf("Failed to read upload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	bundle, err := module.OpenTarBundle(tarball.Name())
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid module set tarball: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer bundle.Close()
+
+	modules, err := module.StoredModules(bundle)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid module set tarball: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Check every module before storing any, so a bad upload stores nothing
+	violations, err := checkPolicy(servePolicy, bundle, modules)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(violations) > 0 {
+		message := ""
+		for _, violation := range violations {
+			logger.Warn("Policy violation", "operator", operator, "error", violation)
+			message += violation.Error() + "\n"
+		}
+		http.Error(writer, strings.TrimSuffix(message, "\n"), http.StatusForbidden)
+		return
+	}
+
+	if err := checkModuleFiles(bundle, modules); err != nil {
+		logger.Security("Rejected upload", "operator", operator, "error", err)
+		http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	for _, m := range modules {
+		if !auth.CanUpload(operator, m.Path) {
+			logger.Warn("Upload denied", "module", m, "user", operator)
+			http.Error(writer, fmt.Sprintf("Access denied to %v", m.Path), http.StatusForbidden)
+			return
+		}
+
+		if uploadVerifyDb {
+			if err := m.Verify(bundle, upstreamSumDb); err != nil {
+				http.Error(writer, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+
+	// Quarantined modules are not served until they are approved
+	target, targetDir := roots[0], rootDirs[0]
+	if uploadQuarantine != nil {
+		target, targetDir = uploadQuarantine, quarantineDir
+	}
+
+	manifest := module.NewManifest("upload-api", operator, request.RemoteAddr, targetDir)
+	result := uploadResult{Modules: []string{}, Quarantined: uploadQuarantine != nil}
+	for _, m := range modules {
+		if uploadQuarantine != nil && isStored(root, m) {
+			continue
+		}
+
+		copied, err := uploadModule(target, bundle, m)
+		if err == nil && copied {
+			err = recordUpload(target, manifest, serveAuditLog, m)
+			result.Uploaded++
+		}
+
+		if err != nil {
+			logger.Error("Failed to upload module", "module", m, "error", err)
+			http.Error(writer, fmt.Sprintf("Failed to upload %v", m), http.StatusInternalServerError)
+			saveManifest(target, manifest)
+			return
+		}
+
+		if copied {
+			result.Modules = append(result.Modules, m.String())
+		}
+	}
+
+	if err := saveManifest(target, manifest); err != nil {
+		logger.Error("Failed to save manifest", "manifest", manifest.ID, "error", err)
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if result.Uploaded > 0 {
+		result.Manifest = manifest.ID
+	}
+
+	logger.Info("Uploaded modules", "operator", operator, "uploaded", result.Uploaded, "modules", len(modules))
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(result)
+}
+
+// Set the time allowed to read the rest of a request
+//
+// Only HTTP/1 connections get a deadline. HTTP/2 connections are shared by
+// many requests, so their requests are limited by -write-timeout instead.
+func setReadDeadline(request *http.Request, timeout time.Duration) {
+	if conn, ok := request.Context().Value(connKey{}).(net.Conn); ok && request.ProtoMajor == 1 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+// Allow an upload to be read for -upload-timeout and its response to be
+// written for -write-timeout after that
+func extendDeadlines(request *http.Request) {
+	setReadDeadline(request, uploadTimeout)
+	if conn, ok := request.Context().Value(connKey{}).(net.Conn); ok && request.ProtoMajor == 1 && writeTimeout != 0 {
+		conn.SetWriteDeadline(time.Now().Add(uploadTimeout + writeTimeout))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/haboustak/goff/internal/module"
+	"github.com/haboustak/goff/internal/vulndb"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Test if a request is for the vulnerability database
+func isVulnDbRequest(requestPath string) bool {
+	return vulnDbPath != "" && strings.HasPrefix(requestPath, vulnDbPath+"/")
+}
+
+// Serve a file of the vulnerability database snapshot stored in the roots
+//
+// Files are stored as .json. Requests for .json.gz, which is what govulncheck
+// asks for over HTTP, are answered with the file compressed on the fly.
+func vulnDb(writer http.ResponseWriter, request *http.Request) {
+	logger.Debug("Request", "path", request.URL.Path)
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + strings.TrimPrefix(request.URL.Path, vulnDbPath))
+	compressed := strings.HasSuffix(name, ".json.gz")
+	name = strings.TrimSuffix(name, ".gz")
+	if !strings.HasSuffix(name, ".json") {
+		http.NotFound(writer, request)
+		return
+	}
+
+	// Files of different snapshots are never mixed
+	var dbRoot module.Storage
+	for _, layer := range roots {
+		if vulndb.Exists(layer) {
+			dbRoot = layer
+			break
+		}
+	}
+
+	if dbRoot == nil {
+		http.NotFound(writer, request)
+		return
+	}
+
+	file, err := dbRoot.Open(path.Join(vulndb.Dir, name))
+	if err != nil {
+		http.NotFound(writer, request)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !compressed {
+		writer.Header().Set("Content-Type", "application/json")
+		http.ServeContent(writer, request, path.Base(name), fileInfo.ModTime(), file)
+		return
+	}
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	if _, err := io.Copy(gzipWriter, file); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := gzipWriter.Close(); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/gzip")
+	http.ServeContent(writer, request, path.Base(name)+".gz", fileInfo.ModTime(), bytes.NewReader(gzipped.Bytes()))
+}
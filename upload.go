@@ -2,35 +2,236 @@ package main
 
 import (
 	"fmt"
+	"github.com/haboustak/goff/internal/audit"
+	"github.com/haboustak/goff/internal/module"
+	"net/url"
+	"os"
 )
 
 var (
-	proxy string
+	proxy        string
+	uploadVerify bool
 )
 
 var cmdUpload = &Command{
 	Name: "upload",
 	Run:  upload,
 	Usage: `Usage:
-    goff [-h] upload [-proxy URI] module_dir
+    goff [-h] upload -proxy ROOT [-quarantine QUARANTINE] [-verify]
+                     [-policy file] [-audit-log file -audit-key file]
+                     [-operator name] module_dir
 
 Upload modules from module_dir to the Go proxy
 
+module_dir and ROOT are directories, .tar archives of module files, or
+S3-compatible buckets given as s3://bucket/prefix?endpoint=URL&region=NAME.
+ROOT is the storage served by "goff serve". Modules that are already stored
+by the proxy are not uploaded again.
+
+With -policy, every module in module_dir is checked against the allow and
+deny rules of the policy file (see "goff help download"). Each module
+version that is denied is reported, and nothing is uploaded if any are.
+
+Module zip files are checked before anything is uploaded. Zip files that
+exceed the go command's size limits, or that hold files with invalid paths,
+case-insensitive name collisions or vendored packages, are rejected. So are
+.info files whose Version is not the canonical version they are stored for,
+or whose Time is missing, in the future or, for pseudo-versions, before the
+time in the version.
+
+Each upload writes a manifest of the uploaded modules and their hashes to
+the manifests directory of ROOT, and adds the modules to the audit log, see
+"goff audit".
+
+With -quarantine, modules that are not already stored in ROOT are uploaded
+to QUARANTINE instead, along with the manifest. QUARANTINE is a directory or
+bucket that is not served, and its modules are moved to ROOT by "goff
+approve" or deleted by "goff reject".
+
 Options:
+    -audit-log
+            append uploaded modules to an audit log (default=GOFF_AUDIT_LOG)
+    -audit-key
+            key used to sign audit records (default=GOFF_AUDIT_KEY)
     -h      show this help
-    -proxy  proxy to upload modules to (default=$GOPROXY)
+    -operator
+            name recorded in the audit log (default=GOFF_OPERATOR or the
+            current user)
+    -policy file of module allow and deny rules (default=GOFF_POLICY)
+    -proxy  storage of the proxy to upload modules to
+    -quarantine
+            storage that holds uploaded modules until they are approved
+            (default=GOFF_QUARANTINE)
+    -verify validate modules against the checksum database before uploading
 `,
 }
 
 func init() {
-	cmdUpload.Flags.StringVar(&proxy, "proxy", "", "proxy to upload modules to")
+	cmdUpload.Flags.StringVar(&proxy, "proxy", "", "proxy storage to upload modules to")
+	cmdUpload.Flags.BoolVar(&uploadVerify, "verify", false, "validate modules against the checksum database")
+	addAuditFlags(&cmdUpload.Flags)
+	addPolicyFlag(&cmdUpload.Flags)
+	addQuarantineFlag(&cmdUpload.Flags, "storage that holds modules until they are approved")
 }
 
-func upload(self *Command) error {
+func upload(self *Command) (err error) {
 	args := self.Flags.Args()
 	if len(args) == 0 {
 		return fmt.Errorf("You must specify the module set directory")
 	}
 
+	if proxy == "" {
+		return fmt.Errorf("You must specify the proxy storage with -proxy")
+	}
+
+	src, err := module.OpenStorage(args[0])
+	if err != nil {
+		return fmt.Errorf("Failed to open module set %v: %v", args[0], err)
+	}
+
+	dst, err := module.CreateStorage(proxy)
+	if err != nil {
+		return fmt.Errorf("Failed to open proxy storage %v: %v", proxy, err)
+	}
+
+	modules, err := module.StoredModules(src)
+	if err != nil {
+		return fmt.Errorf("Failed to read module set %v: %v", args[0], err)
+	}
+
+	policy, err := loadPolicy()
+	if err != nil {
+		return err
+	}
+
+	violations, err := checkPolicy(policy, src, modules)
+	if err != nil {
+		return err
+	}
+
+	if err := reportViolations(violations); err != nil {
+		return err
+	}
+
+	if err := checkModuleFiles(src, modules); err != nil {
+		if _, invalid := err.(*module.InfoError); invalid {
+			logger.Security("Module set has invalid module metadata", "error", err)
+		}
+		return err
+	}
+
+	// Quarantined modules are moved to the proxy storage once approved
+	target, targetSpec := dst, proxy
+	if quarantineDir != "" {
+		if err := checkQuarantineDir([]string{proxy}); err != nil {
+			return err
+		}
+
+		if target, err = openQuarantine(); err != nil {
+			return err
+		}
+		targetSpec = quarantineDir
+	}
+
+	dbUrl, _ := url.Parse("https://sum.golang.org")
+	db := module.NewClient(dbUrl, logger)
+
+	auditLog, err := openAuditLog()
+	if err != nil {
+		return err
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+
+	manifest := module.NewManifest("upload", currentOperator(), args[0], targetSpec)
+	defer func() {
+		if saveErr := saveManifest(target, manifest); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	uploaded := 0
+	for i, m := range modules {
+		logger.Info("Uploading module", "module", m, "progress", fmt.Sprintf("%v/%v", i+1, len(modules)))
+		if target != dst && isStored(dst, m) {
+			continue
+		}
+
+		if uploadVerify {
+			if err := m.Verify(src, db); err != nil {
+				return err
+			}
+		}
+
+		copied, err := uploadModule(target, src, m)
+		if err != nil {
+			return fmt.Errorf("Failed to upload %v: %v", m, err)
+		}
+
+		if copied {
+			if err := recordUpload(target, manifest, auditLog, m); err != nil {
+				return err
+			}
+			uploaded++
+		}
+	}
+
+	logger.Info(fmt.Sprintf("Uploaded %v of %v modules to %v", uploaded, len(modules), targetSpec),
+		"uploaded", uploaded, "modules", len(modules), "proxy", targetSpec)
 	return nil
 }
+
+// Add an uploaded module to the manifest and the audit log
+func recordUpload(dst module.Storage, manifest *module.Manifest, auditLog *audit.Log, m module.Module) error {
+	hashes, err := m.Hashes(dst)
+	if err != nil {
+		return err
+	}
+
+	licenses, err := m.Licenses(dst)
+	if err != nil {
+		return err
+	}
+
+	manifest.Add(m, hashes, licenses)
+	return recordAudit(auditLog, manifest, m, hashes)
+}
+
+// Check that the zip files of modules are safe to extract and that their
+// .info files match their versions before any of them are uploaded
+//
+// Modules without a zip file only have their .info file checked.
+func checkModuleFiles(store module.Storage, modules []module.Module) error {
+	for _, m := range modules {
+		if err := m.CheckZip(store); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if _, err := m.CheckInfo(store); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Copy the files of a module that are missing from the proxy storage
+//
+// The .info file is copied last, because the proxy lists a version as soon
+// as its .info file exists.
+func uploadModule(dst module.Storage, src module.Storage, m module.Module) (bool, error) {
+	copied := false
+	for _, file := range []module.ModuleFile{m.ZipFile(), m.ModuleFile(), m.InfoFile()} {
+		if _, err := dst.Stat(file.FilePath); err == nil {
+			continue
+		}
+
+		if err := module.CopyFile(dst, src, file.FilePath); err != nil {
+			return copied, err
+		}
+		copied = true
+	}
+
+	return copied, nil
+}